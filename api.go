@@ -1,48 +1,226 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
-	"log"
+	"log/slog"
+	"math"
+	"net"
 	"net/http"
+	"net/netip"
+	"net/url"
 	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/gorilla/mux"
+	"golang.org/x/crypto/bcrypt"
 )
 
 type APIServer struct {
 	listenAddr string
 	store      Storage
+	loginGuard *LoginGuard
+	keys       *KeyRing
+	cookies    CookiePolicy
+	oidc       map[string]*OIDCProvider
+	legacy     LegacyPolicy
+	server     ServerConfig
+	workers    *Workers
+	stopping   chan struct{}
+	draining   chan struct{}
+	proxies    []netip.Prefix
+	metrics    *Metrics
+	openAPI    *OpenAPI
+	// migrator parses the embedded migrations on first use, so readiness
+	// probes do not repeat it.
+	migrator func() (*Migrator, error)
 }
 
-func NewAPIServer(listenAddr string, store Storage) *APIServer {
+func NewAPIServer(listenAddr string, server ServerConfig, store Storage, keys *KeyRing, cookies CookiePolicy, oidc map[string]*OIDCProvider, legacy LegacyPolicy) *APIServer {
+	// LoadConfig has already rejected unparseable proxies.
+	proxies, _ := server.Proxies()
 	return &APIServer{
 		listenAddr: listenAddr,
 		store:      store,
+		loginGuard: NewLoginGuard(store),
+		keys:       keys,
+		cookies:    cookies,
+		oidc:       oidc,
+		legacy:     legacy,
+		server:     server,
+		workers:    NewWorkers(),
+		stopping:   make(chan struct{}),
+		draining:   make(chan struct{}),
+		proxies:    proxies,
+		metrics:    NewMetrics(),
+		migrator:   sync.OnceValues(func() (*Migrator, error) { return storeMigrator(store) }),
 	}
 }
 
-func (s *APIServer) Run() {
+// Run listens on the configured address and serves until ctx is
+// cancelled, as Serve does. It returns early if the listener cannot be
+// opened.
+func (s *APIServer) Run(ctx context.Context) error {
+	l, err := net.Listen("tcp", s.listenAddr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(ctx, l)
+}
+
+// Serve serves the API on l and runs the background workers until ctx is
+// cancelled. It then closes stopping so /readyz fails, waits out the
+// shutdown delay while load balancers stop routing here, and closes
+// draining so requests that still arrive get a 503. It stops accepting
+// connections, waits up to the shutdown timeout for in-flight requests and
+// stops the workers.
+func (s *APIServer) Serve(ctx context.Context, l net.Listener) error {
+	srv := &http.Server{
+		Handler:           s.Handler(),
+		ReadHeaderTimeout: s.server.ReadHeaderTimeout,
+		ReadTimeout:       s.server.ReadTimeout,
+		WriteTimeout:      s.server.WriteTimeout,
+		IdleTimeout:       s.server.IdleTimeout,
+		MaxHeaderBytes:    s.server.MaxHeaderBytes,
+	}
+	s.workers.Start(context.WithoutCancel(ctx))
+	defer s.workers.Stop()
+	slog.Info("listening", "addr", l.Addr().String())
+	served := make(chan error, 1)
+	go func() {
+		served <- srv.Serve(l)
+	}()
+	select {
+	case err := <-served:
+		return err
+	case <-ctx.Done():
+	}
+
+	close(s.stopping)
+	if delay := s.server.ShutdownDelay; delay > 0 {
+		slog.Info("shutting down, failing readiness before draining", "delay", delay)
+		time.Sleep(delay)
+	}
+	slog.Info("shutting down, draining in-flight requests")
+	close(s.draining)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), s.server.ShutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		slog.Warn("requests still running, closing them", "timeout", s.server.ShutdownTimeout, "err", err)
+		srv.Close()
+	}
+	<-served
+	return nil
+}
+
+// Handler is the router with the middleware every request goes through.
+func (s *APIServer) Handler() http.Handler {
+	return RequestLogger(s.metrics.Instrument(s.rejectWhileDraining(s.Router())), s.proxies)
+}
+
+// rejectWhileDraining answers 503 once draining has begun. A request can
+// still arrive on a kept-alive connection after the listener closes; it is
+// told to retry elsewhere and its connection is closed rather than starting
+// work the drain would have to wait for.
+func (s *APIServer) rejectWhileDraining(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-s.draining:
+			w.Header().Set("Connection", "close")
+			w.Header().Set("Retry-After", "1")
+			writeProblem(w, r, http.StatusServiceUnavailable, "shutting-down", "The server is shutting down.")
+		default:
+			next.ServeHTTP(w, r)
+		}
+	})
+}
+
+func (s *APIServer) Router() *mux.Router {
 	router := mux.NewRouter()
-	router.HandleFunc("/login", makeHttpHandleFunc(s.handleLogin))
-	router.HandleFunc("/register", makeHttpHandleFunc(s.handleRegister))
-	router.HandleFunc("/accounts", makeHttpHandleFunc(s.handleAccountWithoutParams))
-	router.HandleFunc("/accounts/{id}", AuthGuard(makeHttpHandleFunc(s.handleAccountWithParams)))
-	router.HandleFunc("/teams", AuthGuard(makeHttpHandleFunc(s.handleTeamRoutes)))
-	log.Println("Running on port : ", s.listenAddr)
-	http.ListenAndServe(s.listenAddr, router)
+	router.Use(recordRoute, CSRFGuard)
+	router.HandleFunc("/.well-known/jwks.json", publicCache.Cached(makeHttpHandleFunc(s.handleJWKS))).Methods("GET", "HEAD")
+	router.HandleFunc("/healthz", makeHttpHandleFunc(s.handleHealthz)).Methods("GET")
+	router.HandleFunc("/readyz", makeHttpHandleFunc(s.handleReadyz)).Methods("GET")
+	router.HandleFunc("/metrics", makeHttpHandleFunc(s.handleMetrics)).Methods("GET")
+	router.HandleFunc("/openapi.json", publicCache.Cached(makeHttpHandleFunc(s.handleOpenAPI))).Methods("GET", "HEAD")
+	router.PathPrefix("/docs/").Handler(swaggerUI()).Methods("GET", "HEAD")
+	s.routesV1(router.PathPrefix("/v1").Subrouter())
+	// The unversioned paths predate /v1 and stay as deprecated aliases of
+	// it until the sunset date.
+	legacy := router.NewRoute().Name(legacyRoutes).Subrouter()
+	legacy.Use(Deprecated("/v1", s.legacy))
+	s.routesV1(legacy)
+	// mux reports a method mismatch inside a subrouter as not found, so
+	// both cases look up the methods the path does accept.
+	unmatched := makeHttpHandleFunc(func(w http.ResponseWriter, r *http.Request) error {
+		if allowed := allowedMethods(router, r); len(allowed) > 0 {
+			return MethodNotAllowed(r.Method, allowed...)
+		}
+		return NotFound("No resource at %s.", r.URL.Path)
+	})
+	router.NotFoundHandler = unmatched
+	router.MethodNotAllowedHandler = unmatched
+	s.openAPI = BuildOpenAPI(router)
+	return router
+}
+
+// probedMethods are the methods allowedMethods tries against the router.
+var probedMethods = []string{"GET", "HEAD", "POST", "PUT", "PATCH", "DELETE"}
+
+// allowedMethods lists the methods some route of router accepts for the
+// path of r.
+func allowedMethods(router *mux.Router, r *http.Request) []string {
+	var allowed []string
+	for _, method := range probedMethods {
+		probe := r.Clone(r.Context())
+		probe.Method = method
+		var match mux.RouteMatch
+		if router.Match(probe, &match) && match.MatchErr == nil {
+			allowed = append(allowed, method)
+		}
+	}
+	return allowed
+}
+
+// routesV1 registers the v1 API on r. Handlers write their own response
+// types, so a v2 registers its own routes and handlers next to these and
+// shares only s.store.
+func (s *APIServer) routesV1(r *mux.Router) {
+	r.HandleFunc("/login", makeHttpHandleFunc(s.handleLogin)).Methods("POST")
+	r.HandleFunc("/register", makeHttpHandleFunc(s.handleRegister)).Methods("POST")
+	r.HandleFunc("/auth/{provider}/login", makeHttpHandleFunc(s.handleOIDCLogin)).Methods("GET")
+	r.HandleFunc("/auth/{provider}/callback", makeHttpHandleFunc(s.handleOIDCCallback)).Methods("GET")
+	r.HandleFunc("/accounts", makeHttpHandleFunc(s.handleAccountWithoutParams))
+	r.HandleFunc("/accounts/{id}", s.AuthGuard(privateCache.Cached(makeHttpHandleFunc(s.handleAccountWithParams)))).Methods("GET", "HEAD", "PATCH", "DELETE")
+	r.HandleFunc("/teams", s.AuthGuard(privateCache.Cached(makeHttpHandleFunc(s.handleTeamRoutes)))).Methods("GET", "HEAD", "POST")
+	r.HandleFunc("/teams/{abbr}", s.AuthGuard(makeHttpHandleFunc(s.handleRemoveFavourite))).Methods("DELETE")
+	r.HandleFunc("/me", s.AuthGuard(privateCache.Cached(makeHttpHandleFunc(s.handleMe)))).Methods("GET", "HEAD", "PATCH")
+	r.HandleFunc("/me/favourites", s.AuthGuard(privateCache.Cached(makeHttpHandleFunc(s.handleFavourites)))).Methods("GET", "HEAD", "PUT")
+	r.HandleFunc("/me/favourites/{abbr}", s.AuthGuard(makeHttpHandleFunc(s.handleRemoveFavourite))).Methods("DELETE")
+	r.HandleFunc("/me/follows", s.AuthGuard(privateCache.Cached(makeHttpHandleFunc(s.handleFollows)))).Methods("GET", "HEAD", "POST")
+	r.HandleFunc("/me/follows/{kind}/{ref}", s.AuthGuard(makeHttpHandleFunc(s.handleUnfollow))).Methods("DELETE")
+	r.HandleFunc("/me/export", s.AuthGuard(privateCache.Cached(makeHttpHandleFunc(s.handleExport)))).Methods("GET", "HEAD")
+	r.HandleFunc("/me/sessions", s.AuthGuard(privateCache.Cached(makeHttpHandleFunc(s.handleGetSessions)))).Methods("GET", "HEAD")
+	r.HandleFunc("/me/sessions/{id}", s.AuthGuard(makeHttpHandleFunc(s.handleRevokeSession))).Methods("DELETE")
+	r.HandleFunc("/admin/unlock", s.AuthGuard(s.AdminGuard(makeHttpHandleFunc(s.handleAdminUnlock)))).Methods("POST")
+	r.HandleFunc("/admin/cache", s.AuthGuard(s.AdminGuard(makeHttpHandleFunc(s.handleCacheStats)))).Methods("GET")
 }
 
 func (s *APIServer) handleAccountWithParams(w http.ResponseWriter, r *http.Request) error {
 	switch r.Method {
 	case "GET":
 		return s.handleGetAccount(w, r)
+	case "PATCH":
+		return s.handleUpdateAccount(w, r)
 	case "DELETE":
 		return s.handleDeleteAccount(w, r)
 
 	default:
-		return fmt.Errorf("Invalid method %s", r.Method)
+		return MethodNotAllowed(r.Method, "GET", "PATCH", "DELETE")
 	}
 }
 
@@ -51,7 +229,7 @@ func (s *APIServer) handleAccountWithoutParams(w http.ResponseWriter, r *http.Re
 	// case "POST":
 	// 	return s.handleCreateAccount(w, r)
 	default:
-		return fmt.Errorf("Invalid method %s", r.Method)
+		return MethodNotAllowed(r.Method)
 	}
 }
 
@@ -63,17 +241,13 @@ func (s *APIServer) handleTeamRoutes(w http.ResponseWriter, r *http.Request) err
 		return s.handleAddTeamToFavorite(w, r)
 
 	default:
-		return fmt.Errorf("Invalid method %s", r.Method)
+		return MethodNotAllowed(r.Method, "GET", "POST")
 	}
 }
 
 func (s *APIServer) handleRegister(w http.ResponseWriter, r *http.Request) error {
-	if r.Method != "POST" {
-		return fmt.Errorf("Unallowed method %s : ", r.Method)
-	}
-
 	registerRq := &RegisterRequest{}
-	err := BodyDecoder(registerRq, r.Body)
+	err := BodyDecoder(w, r, registerRq)
 	if err != nil {
 		return err
 	}
@@ -81,62 +255,341 @@ func (s *APIServer) handleRegister(w http.ResponseWriter, r *http.Request) error
 	if err != nil {
 		return err
 	}
-	if err := s.store.CreateAccount(acc); err != nil {
+	if err := s.store.CreateAccount(r.Context(), acc); err != nil {
 		return err
 	}
 	return WriteJSON(w, http.StatusCreated, WithStatusResponse{Status: "Registered successfully."})
 }
 
-func (s *APIServer) handleLogin(w http.ResponseWriter, r *http.Request) error {
-	if r.Method != "POST" {
-		return fmt.Errorf("Unallowed method %s : ", r.Method)
-	}
+func (s *APIServer) handleLogin(w http.ResponseWriter, r *http.Request) (err error) {
+	defer func() { s.metrics.countLogin("password", err) }()
 	loginRq := &LoginRequest{}
-	err := BodyDecoder(loginRq, r.Body)
+	err = BodyDecoder(w, r, loginRq)
+	if err != nil {
+		return err
+	}
+	keys := []loginKey{usernameLoginKey(loginRq.Username), ipLoginKey(clientIP(r))}
+	wait, err := s.loginGuard.Check(r.Context(), keys...)
+	if err != nil {
+		return err
+	}
+	if wait > 0 {
+		return tooManyLoginAttempts(w, wait)
+	}
+	acc, err := s.store.GetAccountByUsername(r.Context(), loginRq.Username)
+	if err != nil && !errors.Is(err, ErrAccountNotFound) {
+		return err
+	}
+	if acc == nil {
+		bcrypt.CompareHashAndPassword(dummyPasswordHash, []byte(loginRq.Password))
+	}
+	if acc == nil || !acc.ValidateAccount(loginRq.Password) {
+		if err := s.loginGuard.Fail(r.Context(), keys...); err != nil {
+			return err
+		}
+		return Unauthorized("Invalid username or password.")
+	}
+	if err := s.loginGuard.Succeed(r.Context(), loginRq.Username); err != nil {
+		return err
+	}
+	if err := s.startSession(w, r, acc); err != nil {
+		return err
+	}
+	return WriteJSON(w, http.StatusOK, WithStatusResponse{Status: "Logged"})
+}
+
+// startSession records a new session for acc and sets the auth and CSRF
+// cookies on the response.
+func (s *APIServer) startSession(w http.ResponseWriter, r *http.Request, acc *Account) error {
+	session := NewSession(acc.Id, r.UserAgent(), clientIP(r))
+	if err := s.store.CreateSession(r.Context(), session); err != nil {
+		return err
+	}
+	token, err := s.CreateJWT(acc, session)
 	if err != nil {
 		return err
 	}
-	acc, err := s.store.GetAccountByUsername(loginRq.Username)
+	csrfToken, err := newCSRFToken()
+	if err != nil {
+		return err
+	}
+	http.SetCookie(w, s.cookies.Cookie("token", token, true))
+	http.SetCookie(w, s.cookies.Cookie(csrfCookieName, csrfToken, false))
+	return nil
+}
+
+func (s *APIServer) handleOIDCLogin(w http.ResponseWriter, r *http.Request) error {
+	provider, ok := s.oidc[mux.Vars(r)["provider"]]
+	if !ok {
+		return NotFound("Unknown provider %s.", mux.Vars(r)["provider"])
+	}
+	flow := &oidcFlow{Provider: provider.Name}
+	var err error
+	if flow.State, err = randomString(16); err != nil {
+		return err
+	}
+	if flow.Nonce, err = randomString(16); err != nil {
+		return err
+	}
+	if flow.Verifier, err = randomString(32); err != nil {
+		return err
+	}
+	authURL, err := provider.AuthCodeURL(r.Context(), flow.State, flow.Nonce, flow.Verifier)
+	if err != nil {
+		return err
+	}
+	if hint := r.URL.Query().Get("login_hint"); hint != "" {
+		authURL += "&" + url.Values{"login_hint": {hint}}.Encode()
+	}
+	value, err := flow.encode()
 	if err != nil {
 		return err
 	}
-	if isValid := acc.ValidateAccount(loginRq.Password); isValid == false {
-		return WriteJSON(w, http.StatusUnauthorized, ApiError{Error: "Invalid password"})
+	cookie := s.cookies.Cookie(oidcFlowCookieName, value, true)
+	cookie.SameSite = http.SameSiteLaxMode
+	cookie.MaxAge = int((10 * time.Minute).Seconds())
+	cookie.Expires = time.Now().Add(10 * time.Minute)
+	http.SetCookie(w, cookie)
+	http.Redirect(w, r, authURL, http.StatusFound)
+	return nil
+}
+
+func (s *APIServer) handleOIDCCallback(w http.ResponseWriter, r *http.Request) (err error) {
+	provider, ok := s.oidc[mux.Vars(r)["provider"]]
+	if !ok {
+		return NotFound("Unknown provider %s.", mux.Vars(r)["provider"])
+	}
+	defer func() { s.metrics.countLogin("oidc", err) }()
+	q := r.URL.Query()
+	if e := q.Get("error"); e != "" {
+		return Unauthorized("Provider returned %s.", e)
 	}
-	token, err := CreateJWT(acc)
+	c, err := r.Cookie(oidcFlowCookieName)
+	if err != nil {
+		return Invalid("Missing login state.")
+	}
+	flow, err := decodeOIDCFlow(c.Value)
+	if err != nil || flow.Provider != provider.Name || flow.State == "" || flow.State != q.Get("state") {
+		return Invalid("Invalid login state.")
+	}
+	expired := s.cookies.Cookie(oidcFlowCookieName, "", true)
+	expired.MaxAge = -1
+	http.SetCookie(w, expired)
+	rawIdToken, err := provider.Exchange(r.Context(), q.Get("code"), flow.Verifier)
+	if err != nil {
+		return &Error{Kind: KindUnauthorized, Message: "Login with provider failed.", Err: err}
+	}
+	identity, err := provider.VerifyIDToken(r.Context(), rawIdToken, flow.Nonce)
+	if err != nil {
+		return &Error{Kind: KindUnauthorized, Message: "Login with provider failed.", Err: err}
+	}
+	acc, err := s.accountForIdentity(r, provider.Name, identity)
 	if err != nil {
 		return err
 	}
-	authCookie := http.Cookie{
-		Name:     "token",
-		Value:    token,
-		HttpOnly: true,
+	if err := s.startSession(w, r, acc); err != nil {
+		return err
 	}
-	http.SetCookie(w, &authCookie)
 	return WriteJSON(w, http.StatusOK, WithStatusResponse{Status: "Logged"})
 }
 
+// accountForIdentity returns the account linked to the provider identity.
+// An unlinked identity is attached to the signed-in account if there is one,
+// otherwise a new password-less account is created for it.
+func (s *APIServer) accountForIdentity(r *http.Request, provider string, identity *OIDCIdentity) (*Account, error) {
+	acc, err := s.store.GetAccountByIdentity(r.Context(), provider, identity.Subject)
+	if !errors.Is(err, ErrAccountNotFound) {
+		return acc, err
+	}
+	if session, ok := s.authenticate(r); ok {
+		acc, err = s.store.GetAccountById(r.Context(), session.AccountId)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		username, err := s.usernameForIdentity(r.Context(), provider, identity)
+		if err != nil {
+			return nil, err
+		}
+		acc = &Account{Username: username}
+		if err := s.store.CreateAccount(r.Context(), acc); err != nil {
+			return nil, err
+		}
+	}
+	if err := s.store.LinkIdentity(r.Context(), acc.Id, provider, identity.Subject); err != nil {
+		return nil, err
+	}
+	return acc, nil
+}
+
+// usernameForIdentity picks a free username that /register would also
+// accept: the provider's preferred username or email local part, else
+// <provider>_<subject>, numbered until it is free. Characters the username
+// rules reject become underscores.
+func (s *APIServer) usernameForIdentity(ctx context.Context, provider string, identity *OIDCIdentity) (string, error) {
+	candidate := identity.PreferredUsername
+	if candidate == "" {
+		candidate, _, _ = strings.Cut(identity.Email, "@")
+	}
+	if name := normaliseUsername(candidate); name != "" {
+		if free, err := s.usernameFree(ctx, name); err != nil || free {
+			return name, err
+		}
+	}
+	base := normaliseUsername(provider + "_" + identity.Subject)
+	if base == "" {
+		base = "user"
+	}
+	for n := 1; ; n++ {
+		name := base
+		if n > 1 {
+			suffix := "_" + strconv.Itoa(n)
+			name = base[:min(len(base), 50-len(suffix))] + suffix
+		}
+		if free, err := s.usernameFree(ctx, name); err != nil || free {
+			return name, err
+		}
+	}
+}
+
+func (s *APIServer) usernameFree(ctx context.Context, username string) (bool, error) {
+	_, err := s.store.GetAccountByUsername(ctx, username)
+	if errors.Is(err, ErrAccountNotFound) {
+		return true, nil
+	}
+	return false, err
+}
+
+// normaliseUsername replaces the characters usernamePattern rejects and cuts
+// the result to length, or returns "" if it is still too short.
+func normaliseUsername(name string) string {
+	name = strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_', r == '.', r == '-':
+			return r
+		}
+		return '_'
+	}, name)
+	if len(name) > 50 {
+		name = name[:50]
+	}
+	if !usernamePattern.MatchString(name) {
+		return ""
+	}
+	return name
+}
+
 func (s *APIServer) handleGetAccount(w http.ResponseWriter, r *http.Request) error {
 	id, err := getIdFromParams(r)
 	if err != nil {
 		return err
 	}
-	acc, err := s.store.GetAccountById(id)
+	if err := s.requireOwnerOrAdmin(r, id); err != nil {
+		return err
+	}
+	acc, err := s.store.GetAccountById(r.Context(), id)
 	if err != nil {
 		return err
 	}
+	setVersionETag(w, "account", acc.Id, acc.Version)
+	setLastModified(w, acc.UpdatedAt)
+	return WriteJSON(w, http.StatusOK, acc)
+}
 
+func (s *APIServer) handleMe(w http.ResponseWriter, r *http.Request) error {
+	accountId := r.Context().Value("accountId").(int)
+	switch r.Method {
+	case "GET":
+		acc, err := s.store.GetAccountById(r.Context(), accountId)
+		if err != nil {
+			return err
+		}
+		setVersionETag(w, "account", acc.Id, acc.Version)
+		setLastModified(w, acc.UpdatedAt)
+		return WriteJSON(w, http.StatusOK, acc)
+	case "PATCH":
+		return s.updateAccount(w, r, accountId)
+	default:
+		return MethodNotAllowed(r.Method, "GET", "PATCH")
+	}
+}
+
+// handleUpdateAccount lets account owners and admins edit an account.
+func (s *APIServer) handleUpdateAccount(w http.ResponseWriter, r *http.Request) error {
+	id, err := getIdFromParams(r)
+	if err != nil {
+		return err
+	}
+	if err := s.requireOwnerOrAdmin(r, id); err != nil {
+		return err
+	}
+	return s.updateAccount(w, r, id)
+}
+
+// requireOwnerOrAdmin rejects callers that neither own account id nor are
+// admins.
+func (s *APIServer) requireOwnerOrAdmin(r *http.Request, id int) error {
+	accountId := r.Context().Value("accountId").(int)
+	if accountId == id {
+		return nil
+	}
+	caller, err := s.store.GetAccountById(r.Context(), accountId)
+	if err != nil {
+		return err
+	}
+	if !caller.IsAdmin {
+		return Forbidden("You can only manage your own account.")
+	}
+	return nil
+}
+
+// updateAccount applies a partial update to account id. Changing your own
+// password requires the current one if the account has one; admins can
+// reset another account's password without it.
+func (s *APIServer) updateAccount(w http.ResponseWriter, r *http.Request, id int) error {
+	updateRq := &UpdateAccountRequest{}
+	if err := BodyDecoder(w, r, updateRq); err != nil {
+		return err
+	}
+	acc, err := s.store.GetAccountById(r.Context(), id)
+	if err != nil {
+		return err
+	}
+	if updateRq.Version != acc.Version {
+		return ErrStaleAccount
+	}
+	if updateRq.Username != nil {
+		acc.Username = *updateRq.Username
+	}
+	if updateRq.Timezone != nil {
+		acc.Timezone = *updateRq.Timezone
+	}
+	if updateRq.TimeFormat != nil {
+		acc.TimeFormat = *updateRq.TimeFormat
+	}
+	if updateRq.Password != nil {
+		self := r.Context().Value("accountId").(int) == id
+		if self && acc.EncryptedPassword != "" && !acc.ValidateAccount(updateRq.CurrentPassword) {
+			return Unauthorized("Current password is incorrect.")
+		}
+		if err := acc.SetPassword(*updateRq.Password); err != nil {
+			return err
+		}
+	}
+	if err := s.store.UpdateAccount(r.Context(), acc); err != nil {
+		return err
+	}
 	return WriteJSON(w, http.StatusOK, acc)
 }
 
 // func (s *APIServer) handleCreateAccount(w http.ResponseWriter, r *http.Request) error {
 // 	createAccRq := &CreateAccountRequest{}
-// 	err := BodyDecoder(createAccRq, r.Body)
+// 	err := BodyDecoder(w, r, createAccRq)
 // 	if err != nil {
 // 		return err
 // 	}
 // 	account := NewAccount(createAccRq.Username, createAccRq.Timezone)
-// 	if err := s.store.CreateAccount(account); err != nil {
+// 	if err := s.store.CreateAccount(r.Context(), account); err != nil {
 // 		return err
 // 	}
 // 	return WriteJSON(w, http.StatusCreated, WithStatusResponse{Status: "Created"})
@@ -147,49 +600,272 @@ func (s *APIServer) handleDeleteAccount(w http.ResponseWriter, r *http.Request)
 	if err != nil {
 		return err
 	}
-	_, err = s.store.GetAccountById(id)
+	if err := s.requireOwnerOrAdmin(r, id); err != nil {
+		return err
+	}
+	if err := s.store.DeleteAccount(r.Context(), id); err != nil {
+		return err
+	}
+	return WriteJSON(w, http.StatusOK, WithStatusResponse{Status: "Deleted"})
+}
+
+// handleExport returns everything stored about the caller as a JSON
+// download.
+func (s *APIServer) handleExport(w http.ResponseWriter, r *http.Request) error {
+	accountId := r.Context().Value("accountId").(int)
+	acc, err := s.store.GetAccountById(r.Context(), accountId)
 	if err != nil {
 		return err
 	}
-	err = s.store.DeleteAccount(id)
+	follows, err := s.store.GetFollows(r.Context(), accountId, "", followList.All())
 	if err != nil {
 		return err
 	}
-	return WriteJSON(w, http.StatusOK, WithStatusResponse{Status: "Deleted"})
+	sessions, err := s.store.GetAllAccountSessions(r.Context(), accountId)
+	if err != nil {
+		return err
+	}
+	identities, err := s.store.GetAccountIdentities(r.Context(), accountId)
+	if err != nil {
+		return err
+	}
+	attempt, err := s.store.GetLoginAttempt(r.Context(), usernameLoginKey(acc.Username).key)
+	if err != nil {
+		return err
+	}
+	export := &AccountExport{
+		ExportedAt:    time.Now().UTC(),
+		Account:       acc,
+		Follows:       follows,
+		Sessions:      make([]*ExportedSession, len(sessions)),
+		Identities:    identities,
+		LoginAttempts: NewExportedLoginAttempts(attempt),
+	}
+	for i, session := range sessions {
+		export.Sessions[i] = &ExportedSession{Session: session, Revoked: session.Revoked}
+	}
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="account-%d-export.json"`, accountId))
+	return WriteJSON(w, http.StatusOK, export)
 }
 
 func (s *APIServer) handleAddTeamToFavorite(w http.ResponseWriter, r *http.Request) error {
-	if r.Method != "POST" {
-		return fmt.Errorf("Unallowed method %s : ", r.Method)
-	}
 	rqBody := &AddToFavourite{}
-	err := BodyDecoder(rqBody, r.Body)
+	err := BodyDecoder(w, r, rqBody)
 	if err != nil {
 		return err
 	}
 	accountId := r.Context().Value("accountId")
-	err = s.store.AddTeamToFavourite(accountId.(int), rqBody.Abbr)
+	follow := &Follow{FollowTarget: FollowTarget{Kind: FollowTeam, Ref: rqBody.Abbr}}
+	created, err := s.store.Follow(r.Context(), accountId.(int), follow)
 	if err != nil {
 		return err
 	}
+	if !created {
+		return WriteJSON(w, http.StatusOK, WithStatusResponse{Status: "Already a favourite."})
+	}
 	return WriteJSON(w, http.StatusCreated, WithStatusResponse{Status: "Created."})
 }
 
-func (s *APIServer) handleGetFavouriteTeams(w http.ResponseWriter, r *http.Request) error {
-	if r.Method != "GET" {
-		return fmt.Errorf("Unallowed method %s : ", r.Method)
+func (s *APIServer) handleRemoveFavourite(w http.ResponseWriter, r *http.Request) error {
+	fav := AddToFavourite{Abbr: mux.Vars(r)["abbr"]}
+	if err := Validate(&fav); err != nil {
+		return err
 	}
+	accountId := r.Context().Value("accountId").(int)
+	target := FollowTarget{Kind: FollowTeam, Ref: fav.Abbr}
+	if err := s.store.Unfollow(r.Context(), accountId, target); err != nil {
+		return err
+	}
+	return WriteJSON(w, http.StatusOK, WithStatusResponse{Status: "Removed."})
+}
+
+func (s *APIServer) handleFavourites(w http.ResponseWriter, r *http.Request) error {
+	switch r.Method {
+	case "GET":
+		return s.handleGetFavouriteTeams(w, r)
+	case "PUT":
+		return s.handleReplaceFavourites(w, r)
+	default:
+		return MethodNotAllowed(r.Method, "GET", "PUT")
+	}
+}
+
+// handleReplaceFavourites replaces the caller's favourites with the list in
+// the body. The list order becomes the favourites order.
+func (s *APIServer) handleReplaceFavourites(w http.ResponseWriter, r *http.Request) error {
+	favourites := []*Favourite{}
+	if err := BodyDecoder(w, r, &favourites); err != nil {
+		return err
+	}
+	follows := make([]*Follow, 0, len(favourites))
+	seen := map[string]bool{}
+	for _, fav := range favourites {
+		if fav == nil {
+			return Invalid("Every favourite needs an abbr.")
+		}
+		if seen[fav.Abbr] {
+			return Invalid("Team %s is listed more than once.", fav.Abbr)
+		}
+		seen[fav.Abbr] = true
+		follows = append(follows, &Follow{FollowTarget: FollowTarget{Kind: FollowTeam, Ref: fav.Abbr}, Notifications: fav.Notifications})
+	}
+	accountId := r.Context().Value("accountId").(int)
+	if err := s.store.ReplaceFollows(r.Context(), accountId, FollowTeam, follows); err != nil {
+		return err
+	}
+	return s.handleGetFavouriteTeams(w, r)
+}
+
+func (s *APIServer) handleGetFavouriteTeams(w http.ResponseWriter, r *http.Request) error {
 	accountId := r.Context().Value("accountId")
-	teams, err := s.store.GetAccountFavouriteTeams(accountId.(int))
+	page, err := ParsePage(r, favouriteList)
 	if err != nil {
 		return err
 	}
-	return WriteJSON(w, http.StatusOK, teams)
+	follows, err := s.store.GetFollows(r.Context(), accountId.(int), FollowTeam, page)
+	if err != nil {
+		return err
+	}
+	follows, next := nextPage(page, follows)
+	return WritePage(w, r, page, favouritesFromFollows(follows), next)
 }
 
-type ApiError struct {
-	Error string `json:"error"`
+func (s *APIServer) handleFollows(w http.ResponseWriter, r *http.Request) error {
+	switch r.Method {
+	case "GET":
+		return s.handleGetFollows(w, r)
+	case "POST":
+		return s.handleFollow(w, r)
+	default:
+		return MethodNotAllowed(r.Method, "GET", "POST")
+	}
 }
+
+// handleGetFollows lists the caller's follows, optionally narrowed with
+// ?kind=team|game|player.
+func (s *APIServer) handleGetFollows(w http.ResponseWriter, r *http.Request) error {
+	kind := FollowKind(r.URL.Query().Get("kind"))
+	if kind != "" && !kind.Valid() {
+		return Invalid("kind must be one of team, game or player.")
+	}
+	page, err := ParsePage(r, followList)
+	if err != nil {
+		return err
+	}
+	accountId := r.Context().Value("accountId").(int)
+	follows, err := s.store.GetFollows(r.Context(), accountId, kind, page)
+	if err != nil {
+		return err
+	}
+	follows, next := nextPage(page, follows)
+	return WritePage(w, r, page, follows, next)
+}
+
+func (s *APIServer) handleFollow(w http.ResponseWriter, r *http.Request) error {
+	follow := &Follow{}
+	if err := BodyDecoder(w, r, follow); err != nil {
+		return err
+	}
+	if err := follow.normalise(); err != nil {
+		return err
+	}
+	accountId := r.Context().Value("accountId").(int)
+	created, err := s.store.Follow(r.Context(), accountId, follow)
+	if err != nil {
+		return err
+	}
+	if !created {
+		return WriteJSON(w, http.StatusOK, WithStatusResponse{Status: "Already following."})
+	}
+	return WriteJSON(w, http.StatusCreated, WithStatusResponse{Status: "Following."})
+}
+
+func (s *APIServer) handleUnfollow(w http.ResponseWriter, r *http.Request) error {
+	vars := mux.Vars(r)
+	target := FollowTarget{Kind: FollowKind(vars["kind"]), Ref: vars["ref"]}
+	if err := Validate(&target); err != nil {
+		return err
+	}
+	if err := target.normalise(); err != nil {
+		return err
+	}
+	accountId := r.Context().Value("accountId").(int)
+	if err := s.store.Unfollow(r.Context(), accountId, target); err != nil {
+		return err
+	}
+	return WriteJSON(w, http.StatusOK, WithStatusResponse{Status: "Unfollowed."})
+}
+
+func (s *APIServer) handleGetSessions(w http.ResponseWriter, r *http.Request) error {
+	accountId := r.Context().Value("accountId").(int)
+	currentId := r.Context().Value("sessionId").(int)
+	page, err := ParsePage(r, sessionList)
+	if err != nil {
+		return err
+	}
+	sessions, err := s.store.GetAccountSessions(r.Context(), accountId, page)
+	if err != nil {
+		return err
+	}
+	sessions, next := nextPage(page, sessions)
+	for _, session := range sessions {
+		session.Current = session.Id == currentId
+	}
+	return WritePage(w, r, page, sessions, next)
+}
+
+func (s *APIServer) handleRevokeSession(w http.ResponseWriter, r *http.Request) error {
+	id, err := getIdFromParams(r)
+	if err != nil {
+		return err
+	}
+	accountId := r.Context().Value("accountId").(int)
+	if err := s.store.RevokeSession(r.Context(), accountId, id); err != nil {
+		return err
+	}
+	return WriteJSON(w, http.StatusOK, WithStatusResponse{Status: "Revoked."})
+}
+
+func (s *APIServer) handleAdminUnlock(w http.ResponseWriter, r *http.Request) error {
+	rqBody := &UnlockRequest{}
+	err := BodyDecoder(w, r, rqBody)
+	if err != nil {
+		return err
+	}
+	keys := []loginKey{}
+	if rqBody.Username != nil {
+		keys = append(keys, usernameLoginKey(*rqBody.Username))
+	}
+	if rqBody.IP != nil {
+		keys = append(keys, ipLoginKey(*rqBody.IP))
+	}
+	if len(keys) == 0 {
+		return Invalid("username or ip is required.")
+	}
+	if err := s.loginGuard.Reset(r.Context(), keys...); err != nil {
+		return err
+	}
+	return WriteJSON(w, http.StatusOK, WithStatusResponse{Status: "Unlocked."})
+}
+
+// handleCacheStats reports the hit and miss counters of the storage cache.
+func (s *APIServer) handleCacheStats(w http.ResponseWriter, r *http.Request) error {
+	cached, ok := s.store.(*CachedStore)
+	if !ok {
+		return NotFound("Caching is disabled.")
+	}
+	return WriteJSON(w, http.StatusOK, cached.Stats.Snapshot())
+}
+
+func (s *APIServer) handleJWKS(w http.ResponseWriter, r *http.Request) error {
+	return WriteJSON(w, http.StatusOK, s.keys.JWKS())
+}
+
+func tooManyLoginAttempts(w http.ResponseWriter, wait time.Duration) error {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	return &Error{Kind: KindTooManyRequests, Message: "Too many failed login attempts. Try again later."}
+}
+
 type apiFunc func(w http.ResponseWriter, r *http.Request) error
 
 func WriteJSON(w http.ResponseWriter, status int, v any) error {
@@ -200,26 +876,34 @@ func WriteJSON(w http.ResponseWriter, status int, v any) error {
 
 func makeHttpHandleFunc(f apiFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		ipAddr := r.RemoteAddr
-
-		fmt.Println("IP Address:", ipAddr)
 		if err := f(w, r); err != nil {
-			// handle errors
-			WriteJSON(w, http.StatusBadRequest, ApiError{Error: err.Error()})
+			if isCanceled(err) {
+				writeCanceled(w, r)
+				return
+			}
+			WriteError(w, r, err)
 		}
 	}
 }
 
+// StatusClientClosedRequest is the non-standard status nginx uses when the
+// client goes away before the response is written.
+const StatusClientClosedRequest = 499
+
+func writeCanceled(w http.ResponseWriter, r *http.Request) {
+	if r.Context().Err() != nil {
+		writeProblem(w, r, StatusClientClosedRequest, "client-closed-request", "Client closed request.")
+		return
+	}
+	w.Header().Set("Retry-After", "1")
+	writeProblem(w, r, http.StatusServiceUnavailable, "timeout", "Request timed out.")
+}
+
 func getIdFromParams(r *http.Request) (int, error) {
 	idStr := mux.Vars(r)["id"]
 	id, err := strconv.Atoi(idStr)
 	if err != nil {
-		return 0, err
+		return 0, Invalid("Invalid id %q.", idStr)
 	}
 	return id, nil
 }
-
-func BodyDecoder(v interface{}, body io.ReadCloser) error {
-	defer body.Close()
-	return json.NewDecoder(body).Decode(v)
-}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+)
+
+// apiTest serves the API over an in-memory store, the way Serve wires it.
+type apiTest struct {
+	s       *APIServer
+	store   Storage
+	handler http.Handler
+}
+
+func newAPITest(t *testing.T) *apiTest {
+	t.Helper()
+	return newAPITestWith(t, NewMemoryStore(), ServerConfig{})
+}
+
+func newAPITestWith(t *testing.T, store Storage, server ServerConfig) *apiTest {
+	t.Helper()
+	keys := NewKeyRing()
+	keys.Add(&SigningKey{Id: legacyKeyId, Method: jwt.SigningMethodHS256, PrivateKey: []byte("secret"), PublicKey: []byte("secret")})
+	s := NewAPIServer("", server, store, keys, CookiePolicy{Path: "/"}, map[string]*OIDCProvider{}, LegacyPolicy{})
+	return &apiTest{s: s, store: store, handler: s.Handler()}
+}
+
+// session signs a token for a new session of acc.
+func (a *apiTest) session(t *testing.T, acc *Account) (string, *Session) {
+	t.Helper()
+	session := NewSession(acc.Id, "test", "192.0.2.1")
+	if err := a.store.CreateSession(context.Background(), session); err != nil {
+		t.Fatal(err)
+	}
+	token, err := a.s.CreateJWT(acc, session)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token, session
+}
+
+func jsonBody(t *testing.T, v any) io.Reader {
+	t.Helper()
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return bytes.NewReader(data)
+}
+
+// do sends a request with token as a bearer token, if set, and body
+// encoded as JSON, if not nil.
+func (a *apiTest) do(t *testing.T, method string, path string, token string, body any, header ...string) *httptest.ResponseRecorder {
+	t.Helper()
+	var r io.Reader
+	if body != nil {
+		r = jsonBody(t, body)
+	}
+	req := httptest.NewRequest(method, path, r)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+	rec := httptest.NewRecorder()
+	a.handler.ServeHTTP(rec, req)
+	return rec
+}
+
+func wantStatus(t *testing.T, rec *httptest.ResponseRecorder, status int) {
+	t.Helper()
+	if rec.Code != status {
+		t.Fatalf("status = %d, want %d: %s", rec.Code, status, rec.Body)
+	}
+}
+
+func decodeBody(t *testing.T, rec *httptest.ResponseRecorder, v any) {
+	t.Helper()
+	if err := json.Unmarshal(rec.Body.Bytes(), v); err != nil {
+		t.Fatalf("decoding %s: %v", rec.Body, err)
+	}
+}
+
+func TestShutdownDrainsInFlightRequests(t *testing.T) {
+	slow := &slowFollowsStore{Storage: NewMemoryStore(), loaded: make(chan struct{}), release: make(chan struct{})}
+	a := newAPITestWith(t, slow, ServerConfig{ShutdownTimeout: 5 * time.Second})
+	acc := mustCreateAccount(t, a.store, "alice")
+	token, _ := a.session(t, acc)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	served := make(chan error, 1)
+	go func() { served <- a.s.Serve(ctx, l) }()
+
+	// A request is in flight, held by the store, when shutdown begins.
+	inFlight := make(chan int, 1)
+	go func() {
+		req, _ := http.NewRequest("GET", "http://"+l.Addr().String()+"/v1/me/follows", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Error(err)
+			inFlight <- 0
+			return
+		}
+		resp.Body.Close()
+		inFlight <- resp.StatusCode
+	}()
+	<-slow.loaded
+	cancel()
+	select {
+	case <-a.s.draining:
+	case <-time.After(5 * time.Second):
+		t.Fatal("draining never began")
+	}
+
+	// New requests are turned away while it finishes.
+	rec := a.do(t, "GET", "/v1/me", token, nil)
+	wantStatus(t, rec, http.StatusServiceUnavailable)
+	var problem ApiError
+	decodeBody(t, rec, &problem)
+	if problem.Type != problemTypeBase+"shutting-down" || rec.Header().Get("Retry-After") == "" || rec.Header().Get("Connection") != "close" {
+		t.Errorf("response = %v %+v, want a shutting-down problem asking to retry", rec.Header(), problem)
+	}
+	select {
+	case err := <-served:
+		t.Fatalf("Serve returned %v before the in-flight request finished", err)
+	default:
+	}
+
+	close(slow.release)
+	if status := <-inFlight; status != http.StatusOK {
+		t.Errorf("in-flight request = %d, want 200", status)
+	}
+	select {
+	case err := <-served:
+		if err != nil {
+			t.Errorf("Serve = %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Serve did not return after the drain")
+	}
+}
+
+func TestRevokedSessionIsRejected(t *testing.T) {
+	a := newAPITest(t)
+	acc := mustCreateAccount(t, a.store, "alice")
+	current, _ := a.session(t, acc)
+	other, otherSession := a.session(t, acc)
+	wantStatus(t, a.do(t, "GET", "/v1/me", other, nil), http.StatusOK)
+
+	wantStatus(t, a.do(t, "DELETE", "/v1/me/sessions/"+strconv.Itoa(otherSession.Id), current, nil), http.StatusOK)
+	wantStatus(t, a.do(t, "GET", "/v1/me", other, nil), http.StatusUnauthorized)
+	// The token cookie is checked the same way.
+	req := httptest.NewRequest("GET", "/v1/me", nil)
+	req.AddCookie(&http.Cookie{Name: "token", Value: other})
+	rec := httptest.NewRecorder()
+	a.handler.ServeHTTP(rec, req)
+	wantStatus(t, rec, http.StatusUnauthorized)
+	wantStatus(t, a.do(t, "GET", "/v1/me", current, nil), http.StatusOK)
+}
+
+func TestTokenForAnotherAccountsSessionIsRejected(t *testing.T) {
+	a := newAPITest(t)
+	alice := mustCreateAccount(t, a.store, "alice")
+	bob := mustCreateAccount(t, a.store, "bob")
+	_, session := a.session(t, alice)
+	forged, err := a.s.CreateJWT(bob, session)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantStatus(t, a.do(t, "GET", "/v1/me", forged, nil), http.StatusUnauthorized)
+}
+
+func TestUpdateAccountVersion(t *testing.T) {
+	a := newAPITest(t)
+	acc := mustCreateAccount(t, a.store, "alice")
+	token, _ := a.session(t, acc)
+	wantStatus(t, a.do(t, "PATCH", "/v1/me", token, map[string]any{"timezone": "Europe/Paris"}), http.StatusUnprocessableEntity)
+
+	rec := a.do(t, "PATCH", "/v1/me", token, map[string]any{"timezone": "Europe/Paris", "version": acc.Version})
+	wantStatus(t, rec, http.StatusOK)
+	var updated Account
+	decodeBody(t, rec, &updated)
+	if updated.Version != acc.Version+1 || updated.Timezone != "Europe/Paris" {
+		t.Fatalf("updated = version %d timezone %q, want version %d timezone Europe/Paris", updated.Version, updated.Timezone, acc.Version+1)
+	}
+
+	// A second writer still holding the old version loses.
+	for _, path := range []string{"/v1/me", "/v1/accounts/" + strconv.Itoa(acc.Id)} {
+		rec = a.do(t, "PATCH", path, token, map[string]any{"timezone": "Asia/Tokyo", "version": acc.Version})
+		wantStatus(t, rec, http.StatusConflict)
+		var problem ApiError
+		decodeBody(t, rec, &problem)
+		if problem.Detail != ErrStaleAccount.Message {
+			t.Errorf("%s: detail = %q, want %q", path, problem.Detail, ErrStaleAccount.Message)
+		}
+	}
+	stored, err := a.store.GetAccountById(context.Background(), acc.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Version != updated.Version || stored.Timezone != "Europe/Paris" {
+		t.Errorf("stored = version %d timezone %q, want the first update kept", stored.Version, stored.Timezone)
+	}
+}
+
+func TestExportIncludesRevokedSessionsAndLoginAttempts(t *testing.T) {
+	a := newAPITest(t)
+	acc := mustCreateAccount(t, a.store, "alice")
+	token, current := a.session(t, acc)
+	_, old := a.session(t, acc)
+	if err := a.store.RevokeSession(context.Background(), acc.Id, old.Id); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.store.RecordLoginFailure(context.Background(), usernameLoginKey("alice").key, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	rec := a.do(t, "GET", "/v1/me/export", token, nil)
+	wantStatus(t, rec, http.StatusOK)
+	var export struct {
+		Sessions []struct {
+			Id      int  `json:"id"`
+			Revoked bool `json:"revoked"`
+		} `json:"sessions"`
+		LoginAttempts struct {
+			Failures    int        `json:"failures"`
+			LastFailure *time.Time `json:"lastFailure"`
+		} `json:"loginAttempts"`
+	}
+	decodeBody(t, rec, &export)
+	if len(export.Sessions) != 2 || export.Sessions[0].Id != current.Id || export.Sessions[0].Revoked ||
+		export.Sessions[1].Id != old.Id || !export.Sessions[1].Revoked {
+		t.Errorf("sessions = %+v, want %d live and %d revoked", export.Sessions, current.Id, old.Id)
+	}
+	if export.LoginAttempts.Failures != 1 || export.LoginAttempts.LastFailure == nil {
+		t.Errorf("loginAttempts = %+v, want one failure", export.LoginAttempts)
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Cache is a byte-valued key-value store with per-key expiry. LRUCache keeps
+// it in process; RedisCache shares it between instances.
+type Cache interface {
+	Get(ctx context.Context, key string) ([]byte, bool, error)
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	Delete(ctx context.Context, keys ...string) error
+}
+
+// OpenCache picks the Cache implementation from the URL scheme: memory:
+// for an LRUCache holding up to size entries, redis:// for a RedisCache. An
+// empty URL disables caching and returns nil. An LRUCache only sees the
+// writes of its own process, so memory: is for a single instance: behind a
+// load balancer the other instances would serve stale data until the TTL.
+func OpenCache(cacheURL string, size int) (Cache, error) {
+	switch {
+	case cacheURL == "":
+		return nil, nil
+	case strings.HasPrefix(cacheURL, "memory:"):
+		return NewLRUCache(size), nil
+	case strings.HasPrefix(cacheURL, "redis://"):
+		return NewRedisCache(cacheURL)
+	default:
+		return nil, fmt.Errorf("unsupported cache URL %q", cacheURL)
+	}
+}
+
+// CacheStats counts lookups so the hit ratio can be watched.
+type CacheStats struct {
+	hits   atomic.Int64
+	misses atomic.Int64
+	errors atomic.Int64
+}
+
+type CacheStatsSnapshot struct {
+	Hits   int64 `json:"hits"`
+	Misses int64 `json:"misses"`
+	Errors int64 `json:"errors"`
+}
+
+func (s *CacheStats) Snapshot() CacheStatsSnapshot {
+	return CacheStatsSnapshot{Hits: s.hits.Load(), Misses: s.misses.Load(), Errors: s.errors.Load()}
+}
+
+// LRUCache evicts the least recently used entry once it holds size
+// entries. Expired entries are dropped when they are next looked up.
+type LRUCache struct {
+	mu    sync.Mutex
+	size  int
+	items map[string]*list.Element
+	order *list.List
+}
+
+type lruEntry struct {
+	key       string
+	value     []byte
+	expiresAt time.Time
+}
+
+func NewLRUCache(size int) *LRUCache {
+	return &LRUCache{size: size, items: map[string]*list.Element{}, order: list.New()}
+}
+
+func (c *LRUCache) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.items[key]
+	if !ok {
+		return nil, false, nil
+	}
+	entry := el.Value.(*lruEntry)
+	if !entry.expiresAt.IsZero() && !time.Now().Before(entry.expiresAt) {
+		c.remove(el)
+		return nil, false, nil
+	}
+	c.order.MoveToFront(el)
+	return entry.value, true, nil
+}
+
+func (c *LRUCache) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry := &lruEntry{key: key, value: value}
+	if ttl > 0 {
+		entry.expiresAt = time.Now().Add(ttl)
+	}
+	if el, ok := c.items[key]; ok {
+		el.Value = entry
+		c.order.MoveToFront(el)
+		return nil
+	}
+	c.items[key] = c.order.PushFront(entry)
+	for c.size > 0 && c.order.Len() > c.size {
+		c.remove(c.order.Back())
+	}
+	return nil
+}
+
+func (c *LRUCache) Delete(ctx context.Context, keys ...string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, key := range keys {
+		if el, ok := c.items[key]; ok {
+			c.remove(el)
+		}
+	}
+	return nil
+}
+
+func (c *LRUCache) remove(el *list.Element) {
+	c.order.Remove(el)
+	delete(c.items, el.Value.(*lruEntry).key)
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// cacheBackends opens a fresh, empty cache of each kind. The Redis backend
+// talks to an in-process FakeRedis.
+var cacheBackends = []struct {
+	name string
+	open func(t *testing.T) Cache
+}{
+	{"lru", func(t *testing.T) Cache { return NewLRUCache(100) }},
+	{"redis", openTestRedis},
+}
+
+func openTestRedis(t *testing.T) Cache {
+	c, err := NewRedisCache(startFakeRedis(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+func wantCached(t *testing.T, c Cache, key, want string) {
+	t.Helper()
+	got, ok, err := c.Get(context.Background(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	switch {
+	case want == "" && ok:
+		t.Errorf("%s = %q, want a miss", key, got)
+	case want != "" && string(got) != want:
+		t.Errorf("%s = %q, %v, want %q", key, got, ok, want)
+	}
+}
+
+func TestCacheSetGetDelete(t *testing.T) {
+	for _, backend := range cacheBackends {
+		t.Run(backend.name, func(t *testing.T) {
+			ctx := context.Background()
+			c := backend.open(t)
+			wantCached(t, c, "a", "")
+			for _, key := range []string{"a", "b", "c"} {
+				if err := c.Set(ctx, key, []byte(key+"1"), 0); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := c.Set(ctx, "a", []byte("a2"), 0); err != nil {
+				t.Fatal(err)
+			}
+			if err := c.Delete(ctx, "b", "c", "missing"); err != nil {
+				t.Fatal(err)
+			}
+			wantCached(t, c, "a", "a2")
+			wantCached(t, c, "b", "")
+			wantCached(t, c, "c", "")
+		})
+	}
+}
+
+func TestCacheExpiry(t *testing.T) {
+	for _, backend := range cacheBackends {
+		t.Run(backend.name, func(t *testing.T) {
+			ctx := context.Background()
+			c := backend.open(t)
+			if err := c.Set(ctx, "short", []byte("x"), 20*time.Millisecond); err != nil {
+				t.Fatal(err)
+			}
+			if err := c.Set(ctx, "long", []byte("y"), time.Minute); err != nil {
+				t.Fatal(err)
+			}
+			wantCached(t, c, "short", "x")
+			time.Sleep(40 * time.Millisecond)
+			wantCached(t, c, "short", "")
+			wantCached(t, c, "long", "y")
+		})
+	}
+}
+
+func TestLRUCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	ctx := context.Background()
+	c := NewLRUCache(2)
+	c.Set(ctx, "a", []byte("a"), 0)
+	c.Set(ctx, "b", []byte("b"), 0)
+	// Reading a makes b the least recently used.
+	wantCached(t, c, "a", "a")
+	c.Set(ctx, "c", []byte("c"), 0)
+	wantCached(t, c, "a", "a")
+	wantCached(t, c, "b", "")
+	wantCached(t, c, "c", "c")
+}
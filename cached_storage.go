@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// CachedStore serves read-heavy Storage calls from a Cache and deletes the
+// affected keys on every write that goes through it:
+//
+//	follows:<account> every follow of an account, read by /me/follows,
+//	                  /me/favourites and /teams, and dropped by Follow,
+//	                  Unfollow, ReplaceFollows and DeleteAccount
+//
+// Each request pages through the cached follows in memory, so one key
+// answers every kind, sort and cursor.
+type CachedStore struct {
+	Storage
+	cache Cache
+	ttl   time.Duration
+	Stats CacheStats
+	// loads tracks the keys being filled, so a load that raced with a
+	// write does not put back what the write replaced.
+	mu    sync.Mutex
+	loads map[string]*cacheLoad
+}
+
+// cacheLoad counts the loads of a key in flight and the invalidations of
+// the key since the first of them started.
+type cacheLoad struct {
+	running    int
+	generation uint64
+}
+
+func NewCachedStore(store Storage, cache Cache, ttl time.Duration) *CachedStore {
+	return &CachedStore{Storage: store, cache: cache, ttl: ttl, loads: map[string]*cacheLoad{}}
+}
+
+func followsCacheKey(accountId int) string {
+	return "follows:" + strconv.Itoa(accountId)
+}
+
+// startLoad registers a load of key and returns the generation it read.
+func (s *CachedStore) startLoad(key string) (*cacheLoad, uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	l := s.loads[key]
+	if l == nil {
+		l = &cacheLoad{}
+		s.loads[key] = l
+	}
+	l.running++
+	return l, l.generation
+}
+
+// stale reports whether key was invalidated since the load started.
+func (s *CachedStore) stale(l *cacheLoad, generation uint64) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return l.generation != generation
+}
+
+func (s *CachedStore) endLoad(key string, l *cacheLoad) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if l.running--; l.running == 0 {
+		delete(s.loads, key)
+	}
+}
+
+// cached decodes key into v, or fills v with load and stores it. Cache
+// failures are counted and logged but never fail the call.
+//
+// A write can commit and invalidate key while load is still reading the old
+// rows. The fill is skipped when key was invalidated during the load, and
+// deleted again when that happened between the check and the Set.
+func (s *CachedStore) cached(ctx context.Context, key string, v any, load func() error) error {
+	data, ok, err := s.cache.Get(ctx, key)
+	if err != nil {
+		s.Stats.errors.Add(1)
+		LoggerFrom(ctx).Warn("cache get failed", "key", key, "err", err)
+	}
+	if ok {
+		if err := json.Unmarshal(data, v); err == nil {
+			s.Stats.hits.Add(1)
+			return nil
+		}
+	}
+	s.Stats.misses.Add(1)
+	l, gen := s.startLoad(key)
+	defer s.endLoad(key, l)
+	if err := load(); err != nil {
+		return err
+	}
+	if s.stale(l, gen) {
+		return nil
+	}
+	if data, err = json.Marshal(v); err == nil {
+		err = s.cache.Set(ctx, key, data, s.ttl)
+	}
+	if err != nil {
+		s.Stats.errors.Add(1)
+		LoggerFrom(ctx).Warn("cache set failed", "key", key, "err", err)
+	}
+	if s.stale(l, gen) {
+		s.invalidate(ctx, key)
+	}
+	return nil
+}
+
+func (s *CachedStore) invalidate(ctx context.Context, keys ...string) {
+	s.mu.Lock()
+	for _, key := range keys {
+		if l := s.loads[key]; l != nil {
+			l.generation++
+		}
+	}
+	s.mu.Unlock()
+	if err := s.cache.Delete(ctx, keys...); err != nil {
+		s.Stats.errors.Add(1)
+		LoggerFrom(ctx).Warn("cache delete failed", "keys", keys, "err", err)
+	}
+}
+
+func (s *CachedStore) GetFollows(ctx context.Context, accountId int, kind FollowKind, page *PageRequest) ([]*Follow, error) {
+	var all []*Follow
+	err := s.cached(ctx, followsCacheKey(accountId), &all, func() (err error) {
+		all, err = s.Storage.GetFollows(ctx, accountId, "", followList.All())
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	follows := []*Follow{}
+	for _, follow := range all {
+		if kind == "" || follow.Kind == kind {
+			follows = append(follows, follow)
+		}
+	}
+	return paginate(follows, page), nil
+}
+
+func (s *CachedStore) Follow(ctx context.Context, accountId int, follow *Follow) (bool, error) {
+	defer s.invalidate(ctx, followsCacheKey(accountId))
+	return s.Storage.Follow(ctx, accountId, follow)
+}
+
+func (s *CachedStore) Unfollow(ctx context.Context, accountId int, target FollowTarget) error {
+	defer s.invalidate(ctx, followsCacheKey(accountId))
+	return s.Storage.Unfollow(ctx, accountId, target)
+}
+
+func (s *CachedStore) ReplaceFollows(ctx context.Context, accountId int, kind FollowKind, follows []*Follow) error {
+	defer s.invalidate(ctx, followsCacheKey(accountId))
+	return s.Storage.ReplaceFollows(ctx, accountId, kind, follows)
+}
+
+func (s *CachedStore) DeleteAccount(ctx context.Context, accountId int) error {
+	defer s.invalidate(ctx, followsCacheKey(accountId))
+	return s.Storage.DeleteAccount(ctx, accountId)
+}
+
+// Close closes the cache, if it holds connections, and then the store.
+func (s *CachedStore) Close() error {
+	if c, ok := s.cache.(io.Closer); ok {
+		if err := c.Close(); err != nil {
+			slog.Warn("closing cache failed", "err", err)
+		}
+	}
+	return s.Storage.Close()
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+// cachedStoreInvalidation checks that reads through a CachedStore see every
+// write made through it, even though the first read filled the cache.
+var cachedStoreInvalidation = []struct {
+	name string
+	run  func(t *testing.T, store *CachedStore)
+}{
+	{"Follow", testCachedFollow},
+	{"Unfollow", testCachedUnfollow},
+	{"ReplaceFollows", testCachedReplaceFollows},
+	{"DeleteAccount", testCachedDeleteAccount},
+}
+
+func TestCachedStoreInvalidation(t *testing.T) {
+	for _, backend := range cacheBackends {
+		t.Run(backend.name, func(t *testing.T) {
+			for _, tt := range cachedStoreInvalidation {
+				t.Run(tt.name, func(t *testing.T) {
+					tt.run(t, NewCachedStore(NewMemoryStore(), backend.open(t), time.Minute))
+				})
+			}
+		})
+	}
+}
+
+func TestCachedStoreServesRepeatReads(t *testing.T) {
+	for _, backend := range cacheBackends {
+		t.Run(backend.name, func(t *testing.T) {
+			store := NewCachedStore(NewMemoryStore(), backend.open(t), time.Minute)
+			acc := mustCreateAccount(t, store, "alice")
+			mustAddTeams(t, store, nbaTeams[0])
+			if _, err := store.Follow(context.Background(), acc.Id, teamFollow(nbaTeams[0].Abbr)); err != nil {
+				t.Fatal(err)
+			}
+			wantFollows(t, store, acc.Id, nbaTeams[0].Abbr)
+			wantFollows(t, store, acc.Id, nbaTeams[0].Abbr)
+			if stats := store.Stats.Snapshot(); stats.Hits != 1 || stats.Misses != 1 || stats.Errors != 0 {
+				t.Errorf("stats = %+v, want one hit and one miss", stats)
+			}
+		})
+	}
+}
+
+func wantFollows(t *testing.T, store Storage, accountId int, abbrs ...string) {
+	t.Helper()
+	follows, err := store.GetFollows(context.Background(), accountId, FollowTeam, followList.All())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, f := range follows {
+		got = append(got, f.Ref)
+	}
+	if len(got) != len(abbrs) {
+		t.Fatalf("follows = %v, want %v", got, abbrs)
+	}
+	for i := range got {
+		if got[i] != abbrs[i] {
+			t.Fatalf("follows = %v, want %v", got, abbrs)
+		}
+	}
+}
+
+func testCachedFollow(t *testing.T, store *CachedStore) {
+	acc := mustCreateAccount(t, store, "alice")
+	mustAddTeams(t, store, nbaTeams[:2]...)
+	wantFollows(t, store, acc.Id)
+	if _, err := store.Follow(context.Background(), acc.Id, teamFollow(nbaTeams[0].Abbr)); err != nil {
+		t.Fatal(err)
+	}
+	wantFollows(t, store, acc.Id, nbaTeams[0].Abbr)
+	if _, err := store.Follow(context.Background(), acc.Id, teamFollow(nbaTeams[1].Abbr)); err != nil {
+		t.Fatal(err)
+	}
+	wantFollows(t, store, acc.Id, nbaTeams[0].Abbr, nbaTeams[1].Abbr)
+}
+
+func testCachedUnfollow(t *testing.T, store *CachedStore) {
+	ctx := context.Background()
+	acc := mustCreateAccount(t, store, "alice")
+	mustAddTeams(t, store, nbaTeams[:2]...)
+	for _, team := range nbaTeams[:2] {
+		if _, err := store.Follow(ctx, acc.Id, teamFollow(team.Abbr)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	wantFollows(t, store, acc.Id, nbaTeams[0].Abbr, nbaTeams[1].Abbr)
+	if err := store.Unfollow(ctx, acc.Id, FollowTarget{Kind: FollowTeam, Ref: nbaTeams[0].Abbr}); err != nil {
+		t.Fatal(err)
+	}
+	wantFollows(t, store, acc.Id, nbaTeams[1].Abbr)
+}
+
+func testCachedReplaceFollows(t *testing.T, store *CachedStore) {
+	ctx := context.Background()
+	acc := mustCreateAccount(t, store, "alice")
+	mustAddTeams(t, store, nbaTeams[:2]...)
+	if _, err := store.Follow(ctx, acc.Id, teamFollow(nbaTeams[0].Abbr)); err != nil {
+		t.Fatal(err)
+	}
+	wantFollows(t, store, acc.Id, nbaTeams[0].Abbr)
+	if err := store.ReplaceFollows(ctx, acc.Id, FollowTeam, []*Follow{teamFollow(nbaTeams[1].Abbr)}); err != nil {
+		t.Fatal(err)
+	}
+	wantFollows(t, store, acc.Id, nbaTeams[1].Abbr)
+}
+
+func testCachedDeleteAccount(t *testing.T, store *CachedStore) {
+	ctx := context.Background()
+	acc := mustCreateAccount(t, store, "alice")
+	mustAddTeams(t, store, nbaTeams[0])
+	if _, err := store.Follow(ctx, acc.Id, teamFollow(nbaTeams[0].Abbr)); err != nil {
+		t.Fatal(err)
+	}
+	wantFollows(t, store, acc.Id, nbaTeams[0].Abbr)
+	if err := store.DeleteAccount(ctx, acc.Id); err != nil {
+		t.Fatal(err)
+	}
+	wantFollows(t, store, acc.Id)
+}
+
+// slowFollowsStore holds the first GetFollows after it has read the
+// follows until release is closed.
+type slowFollowsStore struct {
+	Storage
+	once    sync.Once
+	loaded  chan struct{}
+	release chan struct{}
+}
+
+func (s *slowFollowsStore) GetFollows(ctx context.Context, accountId int, kind FollowKind, page *PageRequest) ([]*Follow, error) {
+	follows, err := s.Storage.GetFollows(ctx, accountId, kind, page)
+	s.once.Do(func() {
+		close(s.loaded)
+		<-s.release
+	})
+	return follows, err
+}
+
+func TestCachedStoreLoadRacingWrite(t *testing.T) {
+	for _, backend := range cacheBackends {
+		t.Run(backend.name, func(t *testing.T) {
+			ctx := context.Background()
+			slow := &slowFollowsStore{Storage: NewMemoryStore(), loaded: make(chan struct{}), release: make(chan struct{})}
+			store := NewCachedStore(slow, backend.open(t), time.Minute)
+			acc := mustCreateAccount(t, store, "alice")
+			mustAddTeams(t, store, nbaTeams[:2]...)
+			if _, err := store.Follow(ctx, acc.Id, teamFollow(nbaTeams[0].Abbr)); err != nil {
+				t.Fatal(err)
+			}
+
+			// A read misses and loads the one follow, then a second follow
+			// commits before the read fills the cache.
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				if _, err := store.GetFollows(ctx, acc.Id, "", followList.All()); err != nil {
+					t.Error(err)
+				}
+			}()
+			<-slow.loaded
+			if _, err := store.Follow(ctx, acc.Id, teamFollow(nbaTeams[1].Abbr)); err != nil {
+				t.Fatal(err)
+			}
+			close(slow.release)
+			<-done
+
+			wantFollows(t, store, acc.Id, nbaTeams[0].Abbr, nbaTeams[1].Abbr)
+			if len(store.loads) != 0 {
+				t.Errorf("loads = %v, want none left", store.loads)
+			}
+		})
+	}
+}
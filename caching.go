@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CachePolicy says who may store a GET response and for how long.
+type CachePolicy struct {
+	Public bool
+	MaxAge time.Duration
+}
+
+var (
+	// publicCache is for responses that are the same for every caller.
+	publicCache = CachePolicy{Public: true, MaxAge: 5 * time.Minute}
+	// privateCache is for per-account responses: browsers may keep them
+	// but must revalidate each time, and shared caches must not store them.
+	privateCache = CachePolicy{}
+)
+
+func (p CachePolicy) header() string {
+	if p.Public {
+		return fmt.Sprintf("public, max-age=%d", int(p.MaxAge.Seconds()))
+	}
+	return "private, no-cache"
+}
+
+// Cached buffers successful GET responses of f and answers If-None-Match
+// and If-Modified-Since with 304 Not Modified. Handlers that know a version
+// of the resource set the ETag with setVersionETag, otherwise it is a hash
+// of the body. Handlers set Last-Modified themselves, and only when the
+// resource has a timestamp that changes with every write. HEAD is served
+// by running f as a GET and dropping the body.
+func (p CachePolicy) Cached(f http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" && r.Method != "HEAD" {
+			f(w, r)
+			return
+		}
+		buf := &bufferedResponse{ResponseWriter: w, head: r.Method == "HEAD"}
+		if buf.head {
+			r = r.Clone(r.Context())
+			r.Method = "GET"
+		}
+		f(buf, r)
+		if buf.status != http.StatusOK {
+			buf.flush()
+			return
+		}
+		h := w.Header()
+		etag := h.Get("ETag")
+		if etag == "" {
+			etag = contentETag(buf.body.Bytes())
+			h.Set("ETag", etag)
+		}
+		h.Set("Cache-Control", p.header())
+		if !p.Public {
+			h.Add("Vary", "Authorization, Cookie")
+		}
+		if notModified(r, etag, h.Get("Last-Modified")) {
+			h.Del("Content-Type")
+			h.Del("Content-Length")
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		buf.flush()
+	}
+}
+
+// contentETag is a strong ETag over body.
+func contentETag(body []byte) string {
+	sum := sha256.Sum256(body)
+	return `"` + base64.RawURLEncoding.EncodeToString(sum[:16]) + `"`
+}
+
+// setVersionETag sets an ETag from the version a resource bumps on every
+// write, which spares Cached hashing the body.
+func setVersionETag(w http.ResponseWriter, kind string, id int, version int) {
+	w.Header().Set("ETag", fmt.Sprintf(`"%s-%d-%d"`, kind, id, version))
+}
+
+// notModified evaluates the conditional GET headers as RFC 9110 orders
+// them: If-Modified-Since is ignored when If-None-Match is present.
+func notModified(r *http.Request, etag string, lastModified string) bool {
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		for _, tag := range strings.Split(inm, ",") {
+			tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+			if tag == "*" || tag == etag {
+				return true
+			}
+		}
+		return false
+	}
+	ims, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	if err != nil || lastModified == "" {
+		return false
+	}
+	modified, err := http.ParseTime(lastModified)
+	return err == nil && !modified.After(ims)
+}
+
+// setLastModified sets Last-Modified to t, truncated to the second
+// precision of HTTP dates.
+func setLastModified(w http.ResponseWriter, t time.Time) {
+	if !t.IsZero() {
+		w.Header().Set("Last-Modified", t.UTC().Format(http.TimeFormat))
+	}
+}
+
+// bufferedResponse holds back the status and body so Cached can decide
+// between the full response and 304. Headers go straight to the
+// underlying writer. The body of a HEAD response is never written.
+type bufferedResponse struct {
+	http.ResponseWriter
+	head   bool
+	status int
+	body   bytes.Buffer
+}
+
+func (b *bufferedResponse) WriteHeader(status int) {
+	if b.status == 0 {
+		b.status = status
+	}
+}
+
+func (b *bufferedResponse) Write(p []byte) (int, error) {
+	if b.status == 0 {
+		b.status = http.StatusOK
+	}
+	return b.body.Write(p)
+}
+
+func (b *bufferedResponse) flush() {
+	if b.status == 0 {
+		b.status = http.StatusOK
+	}
+	if b.head {
+		b.Header().Set("Content-Length", strconv.Itoa(b.body.Len()))
+		b.ResponseWriter.WriteHeader(b.status)
+		return
+	}
+	b.ResponseWriter.WriteHeader(b.status)
+	b.ResponseWriter.Write(b.body.Bytes())
+}
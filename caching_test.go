@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func wantNotModified(t *testing.T, rec *httptest.ResponseRecorder) {
+	t.Helper()
+	wantStatus(t, rec, http.StatusNotModified)
+	if rec.Body.Len() != 0 {
+		t.Errorf("304 body = %q, want none", rec.Body)
+	}
+}
+
+func TestAccountETagFollowsVersion(t *testing.T) {
+	a := newAPITest(t)
+	acc := mustCreateAccount(t, a.store, "alice")
+	token, _ := a.session(t, acc)
+
+	rec := a.do(t, "GET", "/v1/me", token, nil)
+	wantStatus(t, rec, http.StatusOK)
+	etag := rec.Header().Get("ETag")
+	if want := fmt.Sprintf(`"account-%d-%d"`, acc.Id, acc.Version); etag != want {
+		t.Fatalf("ETag = %s, want %s", etag, want)
+	}
+	if cc := rec.Header().Get("Cache-Control"); cc != "private, no-cache" {
+		t.Errorf("Cache-Control = %q, want private, no-cache", cc)
+	}
+	// Both paths to the account share the validator.
+	other := a.do(t, "GET", "/v1/accounts/"+strconv.Itoa(acc.Id), token, nil, "If-None-Match", etag)
+	wantNotModified(t, other)
+	wantNotModified(t, a.do(t, "GET", "/v1/me", token, nil, "If-None-Match", `"stale", `+etag))
+	wantNotModified(t, a.do(t, "GET", "/v1/me", token, nil, "If-None-Match", "W/"+etag))
+
+	wantStatus(t, a.do(t, "PATCH", "/v1/me", token, map[string]any{"timezone": "Europe/Paris", "version": acc.Version}), http.StatusOK)
+	rec = a.do(t, "GET", "/v1/me", token, nil, "If-None-Match", etag)
+	wantStatus(t, rec, http.StatusOK)
+	if got := rec.Header().Get("ETag"); got == etag {
+		t.Errorf("ETag %s did not change after an update", got)
+	}
+}
+
+func TestIfModifiedSince(t *testing.T) {
+	a := newAPITest(t)
+	acc := mustCreateAccount(t, a.store, "alice")
+	token, _ := a.session(t, acc)
+
+	rec := a.do(t, "GET", "/v1/me", token, nil)
+	wantStatus(t, rec, http.StatusOK)
+	lastModified := rec.Header().Get("Last-Modified")
+	modified, err := http.ParseTime(lastModified)
+	if err != nil {
+		t.Fatalf("Last-Modified = %q: %v", lastModified, err)
+	}
+
+	wantNotModified(t, a.do(t, "GET", "/v1/me", token, nil, "If-Modified-Since", lastModified))
+	later := modified.Add(time.Hour).Format(http.TimeFormat)
+	wantNotModified(t, a.do(t, "GET", "/v1/me", token, nil, "If-Modified-Since", later))
+	earlier := modified.Add(-time.Second).Format(http.TimeFormat)
+	wantStatus(t, a.do(t, "GET", "/v1/me", token, nil, "If-Modified-Since", earlier), http.StatusOK)
+	// If-None-Match wins over If-Modified-Since.
+	wantStatus(t, a.do(t, "GET", "/v1/me", token, nil, "If-None-Match", `"stale"`, "If-Modified-Since", later), http.StatusOK)
+	wantStatus(t, a.do(t, "GET", "/v1/me", token, nil, "If-Modified-Since", "yesterday"), http.StatusOK)
+}
+
+func TestListETagHashesBody(t *testing.T) {
+	a := newAPITest(t)
+	acc := mustCreateAccount(t, a.store, "alice")
+	token, _ := a.session(t, acc)
+
+	rec := a.do(t, "GET", "/v1/me/follows", token, nil)
+	wantStatus(t, rec, http.StatusOK)
+	etag := rec.Header().Get("ETag")
+	if etag != contentETag(rec.Body.Bytes()) {
+		t.Fatalf("ETag = %s, want the hash of %s", etag, rec.Body)
+	}
+	wantNotModified(t, a.do(t, "GET", "/v1/me/follows", token, nil, "If-None-Match", etag))
+
+	f := &Follow{FollowTarget: FollowTarget{Kind: FollowPlayer, Ref: "2544"}}
+	if _, err := a.store.Follow(context.Background(), acc.Id, f); err != nil {
+		t.Fatal(err)
+	}
+	wantStatus(t, a.do(t, "GET", "/v1/me/follows", token, nil, "If-None-Match", etag), http.StatusOK)
+}
+
+func TestHeadMirrorsGet(t *testing.T) {
+	a := newAPITest(t)
+	acc := mustCreateAccount(t, a.store, "alice")
+	token, _ := a.session(t, acc)
+
+	for _, path := range []string{"/v1/me", "/v1/me/follows", "/v1/me/sessions", "/v1/me/export", "/me", "/openapi.json", "/.well-known/jwks.json", "/docs/", "/docs/swagger-initializer.js"} {
+		get := a.do(t, "GET", path, token, nil)
+		wantStatus(t, get, http.StatusOK)
+		head := a.do(t, "HEAD", path, token, nil)
+		wantStatus(t, head, http.StatusOK)
+		if head.Body.Len() != 0 {
+			t.Errorf("HEAD %s body = %d bytes, want none", path, head.Body.Len())
+		}
+		if path == "/v1/me/export" {
+			// The export is stamped with the time it was made.
+			continue
+		}
+		if got, want := head.Header().Get("ETag"), get.Header().Get("ETag"); got != want || got == "" {
+			t.Errorf("HEAD %s ETag = %s, want %s", path, got, want)
+		}
+		if got, want := head.Header().Get("Content-Length"), strconv.Itoa(get.Body.Len()); got != want {
+			t.Errorf("HEAD %s Content-Length = %s, want %s", path, got, want)
+		}
+		wantNotModified(t, a.do(t, "HEAD", path, token, nil, "If-None-Match", get.Header().Get("ETag")))
+	}
+}
+
+func TestSwaggerUIETags(t *testing.T) {
+	a := newAPITest(t)
+	for _, path := range []string{"/docs/", "/docs/index.css", "/docs/swagger-initializer.js", "/docs/swagger-ui.css"} {
+		rec := a.do(t, "GET", path, "", nil)
+		wantStatus(t, rec, http.StatusOK)
+		etag := rec.Header().Get("ETag")
+		if etag != contentETag(rec.Body.Bytes()) {
+			t.Errorf("%s ETag = %s, want the hash of the file", path, etag)
+		}
+		if cc := rec.Header().Get("Cache-Control"); cc != publicCache.header() {
+			t.Errorf("%s Cache-Control = %q, want %q", path, cc, publicCache.header())
+		}
+		wantNotModified(t, a.do(t, "GET", path, "", nil, "If-None-Match", etag))
+	}
+}
@@ -0,0 +1,357 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"net/url"
+	"os"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/joho/godotenv"
+	"gopkg.in/yaml.v3"
+)
+
+// Config is loaded from, in increasing order of precedence, the defaults
+// below, an optional YAML file, the environment and command-line flags.
+// Fields tagged secret are redacted whenever the config is printed.
+type Config struct {
+	ListenAddr  string        `yaml:"listen_addr" env:"LISTEN_ADDR" flag:"listen-addr" default:":3000" usage:"address the HTTP server listens on"`
+	DatabaseURL string        `yaml:"database_url" env:"DATABASE_URL" flag:"database-url" default:"postgres://localhost:5432/go-nba?sslmode=disable" secret:"dsn" usage:"postgres://..., sqlite:<path> or memory:"`
+	DBTimeout   time.Duration `yaml:"db_timeout" env:"DB_TIMEOUT" flag:"db-timeout" default:"5s" usage:"timeout for a single storage operation"`
+	Server      ServerConfig  `yaml:"server"`
+	Log         LogConfig     `yaml:"log"`
+	JWT         JWTConfig     `yaml:"jwt"`
+	Cookie      CookieConfig  `yaml:"cookie"`
+	OIDC        OIDCConfig    `yaml:"oidc"`
+	API         APIConfig     `yaml:"api"`
+	Cache       CacheConfig   `yaml:"cache"`
+}
+
+type ServerConfig struct {
+	ReadHeaderTimeout time.Duration `yaml:"read_header_timeout" env:"SERVER_READ_HEADER_TIMEOUT" default:"5s"`
+	ReadTimeout       time.Duration `yaml:"read_timeout" env:"SERVER_READ_TIMEOUT" default:"15s"`
+	WriteTimeout      time.Duration `yaml:"write_timeout" env:"SERVER_WRITE_TIMEOUT" default:"30s"`
+	IdleTimeout       time.Duration `yaml:"idle_timeout" env:"SERVER_IDLE_TIMEOUT" default:"2m"`
+	MaxHeaderBytes    int           `yaml:"max_header_bytes" env:"SERVER_MAX_HEADER_BYTES" default:"65536"`
+	ShutdownTimeout   time.Duration `yaml:"shutdown_timeout" env:"SERVER_SHUTDOWN_TIMEOUT" flag:"shutdown-timeout" default:"20s" usage:"how long to drain in-flight requests on SIGINT or SIGTERM"`
+	ShutdownDelay     time.Duration `yaml:"shutdown_delay" env:"SERVER_SHUTDOWN_DELAY" flag:"shutdown-delay" default:"0s" usage:"how long /readyz fails before draining starts, so load balancers stop routing here"`
+	TrustedProxies    string        `yaml:"trusted_proxies" env:"TRUSTED_PROXIES" usage:"comma separated addresses or CIDRs whose X-Forwarded-For is believed"`
+}
+
+type LogConfig struct {
+	Format string `yaml:"format" env:"LOG_FORMAT" flag:"log-format" default:"text" usage:"text or json"`
+	Level  string `yaml:"level" env:"LOG_LEVEL" flag:"log-level" default:"info" usage:"debug, info, warn or error"`
+}
+
+type JWTConfig struct {
+	Secret    string        `yaml:"secret" env:"JWT_SECRET" secret:"true"`
+	KeysDir   string        `yaml:"keys_dir" env:"JWT_KEYS_DIR" flag:"jwt-keys-dir" usage:"directory of <kid>.pem signing keys"`
+	ActiveKid string        `yaml:"active_kid" env:"JWT_ACTIVE_KID" flag:"jwt-active-kid" usage:"kid of the key new tokens are signed with"`
+	TTL       time.Duration `yaml:"ttl" env:"JWT_TTL" default:"168h" usage:"how long an issued token stays valid"`
+}
+
+type CookieConfig struct {
+	Path     string        `yaml:"path" env:"COOKIE_PATH" default:"/"`
+	Domain   string        `yaml:"domain" env:"COOKIE_DOMAIN"`
+	Secure   bool          `yaml:"secure" env:"COOKIE_SECURE" flag:"cookie-secure" default:"true" usage:"only send auth cookies over HTTPS"`
+	SameSite string        `yaml:"same_site" env:"COOKIE_SAMESITE" default:"lax"`
+	MaxAge   time.Duration `yaml:"max_age" env:"COOKIE_MAX_AGE" default:"168h"`
+}
+
+type OIDCConfig struct {
+	Providers      []OIDCProviderConfig `yaml:"providers"`
+	DevIssuerAddr  string               `yaml:"dev_issuer_addr" env:"OIDC_DEV_ISSUER_ADDR" flag:"oidc-dev-issuer" usage:"start the local test OIDC issuer on this address"`
+	DevRedirectURL string               `yaml:"dev_redirect_url" env:"OIDC_DEV_REDIRECT_URL" default:"http://localhost:3000/v1/auth/dev/callback"`
+}
+
+type APIConfig struct {
+	LegacyDeprecated string `yaml:"legacy_deprecated" env:"API_LEGACY_DEPRECATED" default:"2026-10-19" usage:"date the unversioned routes were deprecated"`
+	LegacySunset     string `yaml:"legacy_sunset" env:"API_LEGACY_SUNSET" flag:"legacy-sunset" default:"2027-04-30" usage:"date the unversioned routes are removed"`
+}
+
+type CacheConfig struct {
+	URL  string        `yaml:"url" env:"CACHE_URL" flag:"cache-url" secret:"dsn" usage:"redis://[:password@]host:port[/db], memory: for a single instance, or empty to disable"`
+	TTL  time.Duration `yaml:"ttl" env:"CACHE_TTL" default:"5m"`
+	Size int           `yaml:"size" env:"CACHE_SIZE" default:"10000"`
+}
+
+type OIDCProviderConfig struct {
+	Name         string `yaml:"name"`
+	Issuer       string `yaml:"issuer"`
+	ClientID     string `yaml:"client_id"`
+	ClientSecret string `yaml:"client_secret" secret:"true"`
+	RedirectURL  string `yaml:"redirect_url"`
+}
+
+const redacted = "[REDACTED]"
+
+// LoadConfig builds the config for the given command-line arguments and
+// returns the arguments left over after the flags.
+func LoadConfig(args []string) (*Config, []string, error) {
+	cfg := &Config{}
+	if err := walkConfig(cfg, func(f reflect.StructField, v reflect.Value) error {
+		if def, ok := f.Tag.Lookup("default"); ok {
+			return setConfigValue(v, def)
+		}
+		return nil
+	}); err != nil {
+		return nil, nil, err
+	}
+
+	fset := flag.NewFlagSet("go-nba", flag.ContinueOnError)
+	configFile := fset.String("config", os.Getenv("CONFIG_FILE"), "optional YAML config file")
+	envFile := fset.String("env-file", ".env", "optional dotenv file loaded into the environment")
+	flagValues := map[string]*string{}
+	walkConfig(cfg, func(f reflect.StructField, v reflect.Value) error {
+		if name := f.Tag.Get("flag"); name != "" {
+			flagValues[name] = fset.String(name, "", f.Tag.Get("usage"))
+		}
+		return nil
+	})
+	if err := fset.Parse(args); err != nil {
+		return nil, nil, err
+	}
+
+	if err := godotenv.Load(*envFile); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, nil, fmt.Errorf("%s: %w", *envFile, err)
+	}
+	if *configFile != "" {
+		f, err := os.Open(*configFile)
+		if err != nil {
+			return nil, nil, err
+		}
+		dec := yaml.NewDecoder(f)
+		dec.KnownFields(true)
+		err = dec.Decode(cfg)
+		f.Close()
+		if err != nil && err != io.EOF {
+			return nil, nil, fmt.Errorf("%s: %w", *configFile, err)
+		}
+	}
+	if err := walkConfig(cfg, func(f reflect.StructField, v reflect.Value) error {
+		name := f.Tag.Get("env")
+		if name == "" {
+			return nil
+		}
+		if s, ok := os.LookupEnv(name); ok && s != "" {
+			if err := setConfigValue(v, s); err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+		}
+		return nil
+	}); err != nil {
+		return nil, nil, err
+	}
+	providers, err := oidcProvidersFromEnv()
+	if err != nil {
+		return nil, nil, err
+	}
+	cfg.OIDC.Providers = append(cfg.OIDC.Providers, providers...)
+
+	set := map[string]bool{}
+	fset.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	if err := walkConfig(cfg, func(f reflect.StructField, v reflect.Value) error {
+		name := f.Tag.Get("flag")
+		if name == "" || !set[name] {
+			return nil
+		}
+		if err := setConfigValue(v, *flagValues[name]); err != nil {
+			return fmt.Errorf("-%s: %w", name, err)
+		}
+		return nil
+	}); err != nil {
+		return nil, nil, err
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, nil, err
+	}
+	return cfg, fset.Args(), nil
+}
+
+// oidcProvidersFromEnv reads OIDC_PROVIDERS (a comma separated list of
+// names) and OIDC_<NAME>_ISSUER, _CLIENT_ID, _CLIENT_SECRET and
+// _REDIRECT_URL for each of them.
+func oidcProvidersFromEnv() ([]OIDCProviderConfig, error) {
+	providers := []OIDCProviderConfig{}
+	for _, name := range strings.Split(os.Getenv("OIDC_PROVIDERS"), ",") {
+		name = strings.TrimSpace(strings.ToLower(name))
+		if name == "" {
+			continue
+		}
+		prefix := "OIDC_" + strings.ToUpper(name) + "_"
+		providers = append(providers, OIDCProviderConfig{
+			Name:         name,
+			Issuer:       os.Getenv(prefix + "ISSUER"),
+			ClientID:     os.Getenv(prefix + "CLIENT_ID"),
+			ClientSecret: os.Getenv(prefix + "CLIENT_SECRET"),
+			RedirectURL:  os.Getenv(prefix + "REDIRECT_URL"),
+		})
+	}
+	return providers, nil
+}
+
+func (c *Config) Validate() error {
+	errs := []error{}
+	if _, _, err := net.SplitHostPort(c.ListenAddr); err != nil {
+		errs = append(errs, fmt.Errorf("listen_addr: %w", err))
+	}
+	if c.Server.ReadHeaderTimeout <= 0 || c.Server.ReadTimeout <= 0 || c.Server.WriteTimeout <= 0 || c.Server.IdleTimeout <= 0 || c.Server.ShutdownTimeout <= 0 {
+		errs = append(errs, fmt.Errorf("server: timeouts must be positive"))
+	}
+	if c.Server.ShutdownDelay < 0 {
+		errs = append(errs, fmt.Errorf("server: shutdown_delay must not be negative"))
+	}
+	if c.Server.MaxHeaderBytes <= 0 {
+		errs = append(errs, fmt.Errorf("server: max_header_bytes must be positive"))
+	}
+	if _, err := c.Server.Proxies(); err != nil {
+		errs = append(errs, fmt.Errorf("server: trusted_proxies: %w", err))
+	}
+	if _, err := c.Log.NewLogger(); err != nil {
+		errs = append(errs, fmt.Errorf("log: %w", err))
+	}
+	if c.DatabaseURL == "" {
+		errs = append(errs, fmt.Errorf("database_url is required"))
+	}
+	if c.JWT.Secret == "" && c.JWT.KeysDir == "" {
+		errs = append(errs, fmt.Errorf("jwt: either secret or keys_dir is required"))
+	}
+	if c.JWT.TTL <= 0 {
+		errs = append(errs, fmt.Errorf("jwt: ttl must be positive"))
+	}
+	if _, err := c.Cookie.Policy(); err != nil {
+		errs = append(errs, fmt.Errorf("cookie: %w", err))
+	}
+	if _, err := OpenCache(c.Cache.URL, c.Cache.Size); err != nil {
+		errs = append(errs, fmt.Errorf("cache: %w", err))
+	}
+	if c.Cache.TTL <= 0 || c.Cache.Size <= 0 {
+		errs = append(errs, fmt.Errorf("cache: ttl and size must be positive"))
+	}
+	if _, err := c.API.Legacy(); err != nil {
+		errs = append(errs, fmt.Errorf("api: %w", err))
+	}
+	seen := map[string]bool{}
+	for _, p := range c.OIDC.Providers {
+		if p.Name == "" || p.Issuer == "" || p.ClientID == "" || p.RedirectURL == "" {
+			errs = append(errs, fmt.Errorf("oidc provider %q: name, issuer, client_id and redirect_url are required", p.Name))
+		}
+		if seen[p.Name] {
+			errs = append(errs, fmt.Errorf("oidc provider %q is configured twice", p.Name))
+		}
+		seen[p.Name] = true
+	}
+	return errors.Join(errs...)
+}
+
+// Redacted returns a copy that is safe to print or log.
+func (c *Config) Redacted() *Config {
+	copied := *c
+	copied.OIDC.Providers = append([]OIDCProviderConfig(nil), c.OIDC.Providers...)
+	walkConfig(&copied, func(f reflect.StructField, v reflect.Value) error {
+		switch f.Tag.Get("secret") {
+		case "true":
+			if v.String() != "" {
+				v.SetString(redacted)
+			}
+		case "dsn":
+			v.SetString(redactDSN(v.String()))
+		}
+		return nil
+	})
+	return &copied
+}
+
+var (
+	dsnPassword      = regexp.MustCompile(`(password=)('[^']*'|\S+)`)
+	dsnQueryPassword = regexp.MustCompile(`((?:^|&)password=)[^&]*`)
+)
+
+// redactDSN hides the password in URL connection strings, whether in the
+// userinfo or a password query parameter, and in key=value ones.
+func redactDSN(dsn string) string {
+	u, err := url.Parse(dsn)
+	if err != nil || u.Scheme == "" || u.Opaque != "" {
+		return dsnPassword.ReplaceAllString(dsn, "${1}xxxxx")
+	}
+	if _, ok := u.User.Password(); ok {
+		u.User = url.UserPassword(u.User.Username(), "xxxxx")
+	}
+	u.RawQuery = dsnQueryPassword.ReplaceAllString(u.RawQuery, "${1}xxxxx")
+	return u.String()
+}
+
+func (c *Config) String() string {
+	out, err := yaml.Marshal(c.Redacted())
+	if err != nil {
+		return err.Error()
+	}
+	return string(out)
+}
+
+// walkConfig calls fn for every leaf field, including the fields of each
+// element of a slice of structs.
+func walkConfig(v any, fn func(reflect.StructField, reflect.Value) error) error {
+	return walkConfigValue(reflect.ValueOf(v).Elem(), fn)
+}
+
+func walkConfigValue(v reflect.Value, fn func(reflect.StructField, reflect.Value) error) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f, fv := t.Field(i), v.Field(i)
+		switch {
+		case fv.Kind() == reflect.Struct:
+			if err := walkConfigValue(fv, fn); err != nil {
+				return err
+			}
+		case fv.Kind() == reflect.Slice && fv.Type().Elem().Kind() == reflect.Struct:
+			for j := 0; j < fv.Len(); j++ {
+				if err := walkConfigValue(fv.Index(j), fn); err != nil {
+					return err
+				}
+			}
+		default:
+			if err := fn(f, fv); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func setConfigValue(v reflect.Value, s string) error {
+	switch {
+	case v.Type() == reflect.TypeOf(time.Duration(0)):
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+	case v.Kind() == reflect.String:
+		v.SetString(s)
+	case v.Kind() == reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case v.Kind() == reflect.Int:
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(n))
+	default:
+		return fmt.Errorf("unsupported config type %s", v.Type())
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// CookiePolicy controls the attributes of the auth and CSRF cookies.
+type CookiePolicy struct {
+	Path     string
+	Domain   string
+	Secure   bool
+	SameSite http.SameSite
+	MaxAge   time.Duration
+}
+
+func (c CookieConfig) Policy() (CookiePolicy, error) {
+	policy := CookiePolicy{
+		Path:   c.Path,
+		Domain: c.Domain,
+		Secure: c.Secure,
+		MaxAge: c.MaxAge,
+	}
+	sameSite, err := parseSameSite(c.SameSite)
+	if err != nil {
+		return policy, err
+	}
+	policy.SameSite = sameSite
+	if policy.SameSite == http.SameSiteNoneMode && !policy.Secure {
+		return policy, fmt.Errorf("same_site=none requires secure=true")
+	}
+	return policy, nil
+}
+
+func parseSameSite(v string) (http.SameSite, error) {
+	switch strings.ToLower(v) {
+	case "lax":
+		return http.SameSiteLaxMode, nil
+	case "strict":
+		return http.SameSiteStrictMode, nil
+	case "none":
+		return http.SameSiteNoneMode, nil
+	default:
+		return 0, fmt.Errorf("unknown same_site value %q", v)
+	}
+}
+
+// Cookie builds a cookie under the policy. A zero MaxAge gives a browser
+// session cookie.
+func (p CookiePolicy) Cookie(name string, value string, httpOnly bool) *http.Cookie {
+	c := &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     p.Path,
+		Domain:   p.Domain,
+		Secure:   p.Secure,
+		SameSite: p.SameSite,
+		HttpOnly: httpOnly,
+	}
+	if p.MaxAge > 0 {
+		c.MaxAge = int(p.MaxAge.Seconds())
+		c.Expires = time.Now().Add(p.MaxAge)
+	}
+	return c
+}
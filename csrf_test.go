@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCSRFGuard(t *testing.T) {
+	tests := []struct {
+		name    string
+		method  string
+		cookies map[string]string
+		header  map[string]string
+		want    int
+	}{
+		{"SafeMethod", "GET", map[string]string{"token": "t"}, nil, http.StatusOK},
+		{"NoAuthCookie", "POST", nil, nil, http.StatusOK},
+		{"Matching", "POST", map[string]string{"token": "t", csrfCookieName: "abc"}, map[string]string{csrfHeaderName: "abc"}, http.StatusOK},
+		{"Mismatched", "POST", map[string]string{"token": "t", csrfCookieName: "abc"}, map[string]string{csrfHeaderName: "abd"}, http.StatusForbidden},
+		{"MissingHeader", "DELETE", map[string]string{"token": "t", csrfCookieName: "abc"}, nil, http.StatusForbidden},
+		{"MissingCookie", "PATCH", map[string]string{"token": "t"}, map[string]string{csrfHeaderName: "abc"}, http.StatusForbidden},
+		{"Bearer", "POST", map[string]string{"token": "t"}, map[string]string{"Authorization": "Bearer t"}, http.StatusOK},
+	}
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "/v1/me/follows", nil)
+			for name, value := range tt.cookies {
+				req.AddCookie(&http.Cookie{Name: name, Value: value})
+			}
+			for name, value := range tt.header {
+				req.Header.Set(name, value)
+			}
+			rec := httptest.NewRecorder()
+			CSRFGuard(next).ServeHTTP(rec, req)
+			if rec.Code != tt.want {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestCookiePostWithoutCSRFTokenIsForbidden(t *testing.T) {
+	a := newAPITest(t)
+	acc := mustCreateAccount(t, a.store, "alice")
+	mustAddTeams(t, a.store, nbaTeams[0])
+	token, _ := a.session(t, acc)
+	post := func(csrf string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/v1/me/follows", jsonBody(t, teamFollow(nbaTeams[0].Abbr)))
+		req.AddCookie(&http.Cookie{Name: "token", Value: token})
+		req.AddCookie(&http.Cookie{Name: csrfCookieName, Value: "abc"})
+		if csrf != "" {
+			req.Header.Set(csrfHeaderName, csrf)
+		}
+		rec := httptest.NewRecorder()
+		a.handler.ServeHTTP(rec, req)
+		return rec
+	}
+	wantStatus(t, post(""), http.StatusForbidden)
+	if follows, _ := a.store.GetFollows(context.Background(), acc.Id, "", followList.All()); len(follows) != 0 {
+		t.Fatalf("follow created despite the CSRF failure: %v", follows)
+	}
+	if rec := post("abc"); rec.Code >= 300 {
+		t.Fatalf("status with the CSRF token = %d: %s", rec.Code, rec.Body)
+	}
+}
+
+func TestCookiePolicyMaxAge(t *testing.T) {
+	session := CookiePolicy{Path: "/"}.Cookie("token", "t", true)
+	if session.MaxAge != 0 || !session.Expires.IsZero() {
+		t.Errorf("zero max age gave MaxAge %d Expires %s, want a session cookie", session.MaxAge, session.Expires)
+	}
+	remembered := CookiePolicy{Path: "/", MaxAge: time.Hour}.Cookie("token", "t", true)
+	if remembered.MaxAge != 3600 || remembered.Expires.IsZero() {
+		t.Errorf("one hour max age gave MaxAge %d Expires %s", remembered.MaxAge, remembered.Expires)
+	}
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/lib/pq"
+	"github.com/mattn/go-sqlite3"
+)
+
+type ErrorKind string
+
+const (
+	KindValidation       ErrorKind = "validation"
+	KindUnauthorized     ErrorKind = "unauthorized"
+	KindForbidden        ErrorKind = "forbidden"
+	KindNotFound         ErrorKind = "not-found"
+	KindMethodNotAllowed ErrorKind = "method-not-allowed"
+	KindConflict         ErrorKind = "conflict"
+	KindTooLarge         ErrorKind = "too-large"
+	KindTooManyRequests  ErrorKind = "too-many-requests"
+	KindInternal         ErrorKind = "internal"
+)
+
+var kindStatus = map[ErrorKind]int{
+	KindValidation:       http.StatusUnprocessableEntity,
+	KindUnauthorized:     http.StatusUnauthorized,
+	KindForbidden:        http.StatusForbidden,
+	KindNotFound:         http.StatusNotFound,
+	KindMethodNotAllowed: http.StatusMethodNotAllowed,
+	KindConflict:         http.StatusConflict,
+	KindTooLarge:         http.StatusRequestEntityTooLarge,
+	KindTooManyRequests:  http.StatusTooManyRequests,
+	KindInternal:         http.StatusInternalServerError,
+}
+
+// Error is the domain error returned by Storage and handlers. Message and
+// Fields are shown to the client; Err is the underlying cause and is only
+// logged.
+type Error struct {
+	Kind    ErrorKind
+	Message string
+	Err     error
+	Fields  []FieldError
+	// Allow lists the methods the resource does accept, for the Allow
+	// header of a 405.
+	Allow []string
+}
+
+func (e *Error) Error() string {
+	if e.Err != nil {
+		return e.Message + ": " + e.Err.Error()
+	}
+	return e.Message
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Is makes errors.Is match on kind and message, so a wrapped copy of a
+// sentinel such as ErrAccountNotFound still matches it.
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	return ok && t.Kind == e.Kind && t.Message == e.Message
+}
+
+func NotFound(format string, args ...any) *Error {
+	return &Error{Kind: KindNotFound, Message: fmt.Sprintf(format, args...)}
+}
+
+func Conflict(format string, args ...any) *Error {
+	return &Error{Kind: KindConflict, Message: fmt.Sprintf(format, args...)}
+}
+
+func Invalid(format string, args ...any) *Error {
+	return &Error{Kind: KindValidation, Message: fmt.Sprintf(format, args...)}
+}
+
+func Unauthorized(format string, args ...any) *Error {
+	return &Error{Kind: KindUnauthorized, Message: fmt.Sprintf(format, args...)}
+}
+
+func Forbidden(format string, args ...any) *Error {
+	return &Error{Kind: KindForbidden, Message: fmt.Sprintf(format, args...)}
+}
+
+func MethodNotAllowed(method string, allowed ...string) *Error {
+	return &Error{Kind: KindMethodNotAllowed, Message: fmt.Sprintf("Method %s is not allowed.", method), Allow: allowed}
+}
+
+func Internal(err error) *Error {
+	return &Error{Kind: KindInternal, Message: "Internal server error.", Err: err}
+}
+
+// ApiError is an RFC 7807 problem details body.
+type ApiError struct {
+	Type     string       `json:"type"`
+	Title    string       `json:"title"`
+	Status   int          `json:"status"`
+	Detail   string       `json:"detail,omitempty"`
+	Instance string       `json:"instance,omitempty"`
+	Errors   []FieldError `json:"errors,omitempty"`
+}
+
+// problemTypeBase prefixes the problem type, a URI reference relative to the
+// API root.
+const problemTypeBase = "/problems/"
+
+// WriteError renders err as problem+json. Errors that are not an *Error are
+// treated as internal so raw driver messages never reach the client.
+func WriteError(w http.ResponseWriter, r *http.Request, err error) {
+	var e *Error
+	if !errors.As(err, &e) {
+		e = Internal(err)
+	}
+	if e.Kind == KindInternal {
+		LoggerFrom(r.Context()).Error("internal error", "method", r.Method, "path", r.URL.Path, "err", e.Err)
+	}
+	status, ok := kindStatus[e.Kind]
+	if !ok {
+		status = http.StatusInternalServerError
+	}
+	if e.Kind == KindMethodNotAllowed {
+		// RFC 9110 requires Allow on a 405, even when it is empty.
+		w.Header().Set("Allow", strings.Join(e.Allow, ", "))
+	}
+	writeProblem(w, r, status, string(e.Kind), e.Message, e.Fields...)
+}
+
+func writeProblem(w http.ResponseWriter, r *http.Request, status int, kind string, detail string, fields ...FieldError) {
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(ApiError{
+		Type:     problemTypeBase + kind,
+		Title:    problemTitle(status),
+		Status:   status,
+		Detail:   detail,
+		Instance: r.URL.Path,
+		Errors:   fields,
+	})
+}
+
+func problemTitle(status int) string {
+	if status == StatusClientClosedRequest {
+		return "Client Closed Request"
+	}
+	return http.StatusText(status)
+}
+
+// translateDBError maps driver constraint violations to domain errors. The
+// messages name the offending field rather than echoing the SQL error.
+func translateDBError(err error) error {
+	if err == nil || err == sql.ErrNoRows || isCanceled(err) {
+		return err
+	}
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		switch pqErr.Code {
+		case "23505":
+			return conflictFor(pqErr.Table, pqErr.Constraint, err)
+		case "23503":
+			if strings.Contains(pqErr.Detail, "still referenced") {
+				return &Error{Kind: KindConflict, Message: fmt.Sprintf("The %s is still in use.", singular(pqErr.Table)), Err: err}
+			}
+			return &Error{Kind: KindValidation, Message: "A referenced resource does not exist.", Err: err}
+		case "23514", "22001":
+			return &Error{Kind: KindValidation, Message: "A value is out of range or too long.", Err: err}
+		}
+		return Internal(err)
+	}
+	var liteErr sqlite3.Error
+	if errors.As(err, &liteErr) {
+		switch liteErr.ExtendedCode {
+		case sqlite3.ErrConstraintUnique, sqlite3.ErrConstraintPrimaryKey:
+			table, _, _ := strings.Cut(strings.TrimPrefix(liteErr.Error(), "UNIQUE constraint failed: "), ".")
+			return conflictFor(table, "", err)
+		case sqlite3.ErrConstraintForeignKey:
+			// SQLite does not say which side of the key failed. Deletes
+			// remove dependent rows first, so only inserts and updates
+			// get here, which Postgres and MemoryStore report the same way.
+			return &Error{Kind: KindValidation, Message: "A referenced resource does not exist.", Err: err}
+		case sqlite3.ErrConstraintCheck, sqlite3.ErrConstraintNotNull:
+			return &Error{Kind: KindValidation, Message: "A value is out of range or too long.", Err: err}
+		}
+		return Internal(err)
+	}
+	return err
+}
+
+func conflictFor(table string, constraint string, err error) *Error {
+	switch {
+	case table == "accounts" || strings.HasPrefix(constraint, "accounts_username"):
+		return &Error{Kind: KindConflict, Message: "Username is already taken.", Err: err}
+	case table == "follows":
+		return &Error{Kind: KindConflict, Message: "Already following it.", Err: err}
+	case table == "teams":
+		return &Error{Kind: KindConflict, Message: "Team already exists.", Err: err}
+	case table == "account_identities":
+		return &Error{Kind: KindConflict, Message: "Identity is already linked to an account.", Err: err}
+	default:
+		return &Error{Kind: KindConflict, Message: "Resource already exists.", Err: err}
+	}
+}
+
+func singular(table string) string {
+	return strings.TrimSuffix(table, "s")
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+func TestCanceledRequestStatus(t *testing.T) {
+	tests := []struct {
+		name          string
+		cancelRequest bool
+		err           error
+		want          int
+	}{
+		{"ClientGone", true, context.Canceled, StatusClientClosedRequest},
+		{"ClientGoneWrapped", true, fmt.Errorf("loading follows: %w", context.Canceled), StatusClientClosedRequest},
+		{"OperationTimeout", false, context.DeadlineExceeded, http.StatusServiceUnavailable},
+		{"PostgresCancel", false, &pq.Error{Code: "57014"}, http.StatusServiceUnavailable},
+		{"OtherError", false, fmt.Errorf("boom"), http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			if tt.cancelRequest {
+				cancel()
+			}
+			req := httptest.NewRequest("GET", "/v1/me", nil).WithContext(ctx)
+			rec := httptest.NewRecorder()
+			makeHttpHandleFunc(func(http.ResponseWriter, *http.Request) error { return tt.err })(rec, req)
+			if rec.Code != tt.want {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.want, rec.Body)
+			}
+			if got := rec.Header().Get("Retry-After"); (tt.want == http.StatusServiceUnavailable) != (got != "") {
+				t.Errorf("Retry-After = %q", got)
+			}
+			if ct := rec.Header().Get("Content-Type"); ct != "application/problem+json" {
+				t.Errorf("Content-Type = %q, want problem+json", ct)
+			}
+		})
+	}
+}
+
+// slowStore gives every account lookup a short per-operation timeout, like
+// sqlStore.withTimeout, and never answers within it.
+type slowStore struct {
+	*MemoryStore
+}
+
+func (s slowStore) GetAccountById(ctx context.Context, id int) (*Account, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Millisecond)
+	defer cancel()
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func TestSlowStorageStatus(t *testing.T) {
+	store := slowStore{NewMemoryStore()}
+	a := newAPITestWith(t, store, ServerConfig{})
+	acc := mustCreateAccount(t, store, "alice")
+	token, _ := a.session(t, acc)
+
+	// The operation timing out while the client waits is a 503.
+	wantStatus(t, a.do(t, "GET", "/v1/me", token, nil), http.StatusServiceUnavailable)
+
+	// The client going away first is a 499.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	req := httptest.NewRequest("GET", "/v1/me", nil).WithContext(ctx)
+	req.Header.Set("Authorization", "Bearer "+token)
+	rec := httptest.NewRecorder()
+	a.handler.ServeHTTP(rec, req)
+	wantStatus(t, rec, StatusClientClosedRequest)
+}
+
+func TestUnmatchedRequestsAreProblems(t *testing.T) {
+	a := newAPITest(t)
+
+	rec := a.do(t, "GET", "/v1/nope", "", nil)
+	wantStatus(t, rec, http.StatusNotFound)
+	if ct := rec.Header().Get("Content-Type"); ct != "application/problem+json" {
+		t.Errorf("404 Content-Type = %q, want application/problem+json", ct)
+	}
+
+	rec = a.do(t, "DELETE", "/v1/login", "", nil)
+	wantStatus(t, rec, http.StatusMethodNotAllowed)
+	if allow := rec.Header().Get("Allow"); allow != "POST" {
+		t.Errorf("Allow = %q, want POST", allow)
+	}
+
+	// Subrouter paths, versioned or not, get the methods their route
+	// registers.
+	for _, path := range []string{"/v1/me", "/me"} {
+		rec = a.do(t, "DELETE", path, "", nil)
+		wantStatus(t, rec, http.StatusMethodNotAllowed)
+		if allow := rec.Header().Get("Allow"); allow != "GET, HEAD, PATCH" {
+			t.Errorf("%s: Allow = %q, want GET, HEAD, PATCH", path, allow)
+		}
+	}
+
+	rec = a.do(t, "GET", "/v1/accounts", "", nil)
+	wantStatus(t, rec, http.StatusMethodNotAllowed)
+	if allow, ok := rec.Header()["Allow"]; !ok || allow[0] != "" {
+		t.Errorf("Allow = %q, want present and empty", allow)
+	}
+}
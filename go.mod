@@ -12,3 +12,9 @@ require (
 require github.com/golang-jwt/jwt v3.2.2+incompatible
 
 require github.com/joho/godotenv v1.5.1
+
+require github.com/mattn/go-sqlite3 v1.14.22
+
+require gopkg.in/yaml.v3 v3.0.1
+
+require github.com/swaggo/files/v2 v2.0.2
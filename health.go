@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// readinessTimeout bounds the checks behind /readyz so a hung database
+// fails the probe instead of stalling it.
+const readinessTimeout = 2 * time.Second
+
+type HealthCheck struct {
+	Status string `json:"status"`
+	Detail string `json:"detail,omitempty"`
+}
+
+type HealthReport struct {
+	Status string                 `json:"status"`
+	Checks map[string]HealthCheck `json:"checks,omitempty"`
+}
+
+func passed(detail string) HealthCheck {
+	return HealthCheck{Status: "ok", Detail: detail}
+}
+
+func failed(format string, args ...any) HealthCheck {
+	return HealthCheck{Status: "fail", Detail: fmt.Sprintf(format, args...)}
+}
+
+// handleHealthz reports that the process is up and serving. It checks
+// nothing else, so a slow database never gets the process restarted.
+func (s *APIServer) handleHealthz(w http.ResponseWriter, r *http.Request) error {
+	return WriteJSON(w, http.StatusOK, HealthReport{Status: "ok"})
+}
+
+// handleReadyz reports whether this instance should receive traffic: it is
+// not shutting down, the database answers, the schema is at the latest
+// migration and every background worker is running. Any failing check
+// turns the response into a 503. The probe is unauthenticated, so errors
+// are logged and the report only names what failed.
+func (s *APIServer) handleReadyz(w http.ResponseWriter, r *http.Request) error {
+	ctx, cancel := context.WithTimeout(r.Context(), readinessTimeout)
+	defer cancel()
+	report := HealthReport{Status: "ok", Checks: map[string]HealthCheck{
+		"shutdown":   s.checkShutdown(),
+		"database":   s.checkDatabase(ctx),
+		"migrations": s.checkMigrations(ctx),
+		"workers":    s.checkWorkers(),
+	}}
+	status := http.StatusOK
+	for _, check := range report.Checks {
+		if check.Status != "ok" {
+			report.Status = "fail"
+			status = http.StatusServiceUnavailable
+		}
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	return WriteJSON(w, status, report)
+}
+
+func (s *APIServer) checkShutdown() HealthCheck {
+	select {
+	case <-s.stopping:
+		return failed("shutting down")
+	default:
+		return passed("")
+	}
+}
+
+func (s *APIServer) checkDatabase(ctx context.Context) HealthCheck {
+	start := time.Now()
+	if err := s.store.Ping(ctx); err != nil {
+		LoggerFrom(ctx).Warn("readiness: database ping failed", "err", err)
+		return failed("database unavailable")
+	}
+	return passed(fmt.Sprintf("ping took %s", time.Since(start).Round(time.Microsecond)))
+}
+
+// checkMigrations fails only while the schema is behind this binary. A
+// schema ahead of it is expected during a rolling deploy, when a newer
+// replica has already migrated and the older ones keep serving.
+func (s *APIServer) checkMigrations(ctx context.Context) HealthCheck {
+	migrator, err := s.migrator()
+	if err != nil {
+		LoggerFrom(ctx).Warn("readiness: loading migrations failed", "err", err)
+		return failed("migrations failed")
+	}
+	if migrator == nil {
+		return passed("storage has no migrations")
+	}
+	applied, err := migrator.AppliedVersion(ctx)
+	if err != nil {
+		LoggerFrom(ctx).Warn("readiness: reading the schema version failed", "err", err)
+		return failed("migrations failed")
+	}
+	latest := migrator.LatestVersion()
+	switch {
+	case applied < latest:
+		return failed("schema at version %d, expected %d", applied, latest)
+	case applied > latest:
+		return passed(fmt.Sprintf("schema at version %d, ahead of %d", applied, latest))
+	}
+	return passed(fmt.Sprintf("schema at version %d", applied))
+}
+
+// storeMigrator returns the Migrator behind store, looking through a
+// CachedStore, or nil if the storage has no migrations.
+func storeMigrator(store Storage) (*Migrator, error) {
+	if cached, ok := store.(*CachedStore); ok {
+		store = cached.Storage
+	}
+	m, ok := store.(migratable)
+	if !ok {
+		return nil, nil
+	}
+	return m.Migrator()
+}
+
+func (s *APIServer) checkWorkers() HealthCheck {
+	var stopped []string
+	running := s.workers.Running()
+	for name, ok := range running {
+		if !ok {
+			stopped = append(stopped, name)
+		}
+	}
+	if len(stopped) > 0 {
+		sort.Strings(stopped)
+		return failed("stopped: %s", strings.Join(stopped, ", "))
+	}
+	return passed(fmt.Sprintf("%d running", len(running)))
+}
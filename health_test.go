@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/golang-jwt/jwt"
+)
+
+func newHealthTestServer(t *testing.T, store Storage) *APIServer {
+	t.Helper()
+	keys := NewKeyRing()
+	keys.Add(&SigningKey{Id: legacyKeyId, Method: jwt.SigningMethodHS256, PrivateKey: []byte("secret"), PublicKey: []byte("secret")})
+	return NewAPIServer("", ServerConfig{}, store, keys, CookiePolicy{Path: "/"}, map[string]*OIDCProvider{}, LegacyPolicy{})
+}
+
+func getReadyz(t *testing.T, s *APIServer) (int, HealthReport) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	RequestLogger(s.Router(), nil).ServeHTTP(rec, httptest.NewRequest("GET", "/readyz", nil))
+	var report HealthReport
+	if err := json.Unmarshal(rec.Body.Bytes(), &report); err != nil {
+		t.Fatal(err)
+	}
+	return rec.Code, report
+}
+
+func mustMigrator(t *testing.T, store Storage) *Migrator {
+	t.Helper()
+	migrator, err := store.(migratable).Migrator()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return migrator
+}
+
+func TestReadyz(t *testing.T) {
+	tests := []struct {
+		name       string
+		setup      func(t *testing.T, s *APIServer, store Storage)
+		wantStatus int
+		wantFailed string
+	}{
+		{"Ready", func(*testing.T, *APIServer, Storage) {}, http.StatusOK, ""},
+		{"ShuttingDown", func(_ *testing.T, s *APIServer, _ Storage) { close(s.stopping) }, http.StatusServiceUnavailable, "shutdown"},
+		{"SchemaBehind", func(t *testing.T, _ *APIServer, store Storage) {
+			if err := mustMigrator(t, store).Down(1); err != nil {
+				t.Fatal(err)
+			}
+		}, http.StatusServiceUnavailable, "migrations"},
+		// A newer replica has migrated past this binary mid-deploy.
+		{"SchemaAhead", func(t *testing.T, _ *APIServer, store Storage) {
+			migrator := mustMigrator(t, store)
+			_, err := migrator.db.ExecContext(context.Background(), `insert into schema_migrations (version, name) values ($1, 'future')`, migrator.LatestVersion()+1)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}, http.StatusOK, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := openTestSQLite(t)
+			s := newHealthTestServer(t, store)
+			tt.setup(t, s, store)
+			status, report := getReadyz(t, s)
+			if status != tt.wantStatus {
+				t.Errorf("status = %d, want %d: %+v", status, tt.wantStatus, report)
+			}
+			for name, check := range report.Checks {
+				if fail := check.Status != "ok"; fail != (name == tt.wantFailed) {
+					t.Errorf("check %s = %+v", name, check)
+				}
+			}
+		})
+	}
+}
+
+func TestReadyzParsesMigrationsOnce(t *testing.T) {
+	s := newHealthTestServer(t, openTestSQLite(t))
+	first, err := s.migrator()
+	if err != nil {
+		t.Fatal(err)
+	}
+	getReadyz(t, s)
+	if again, _ := s.migrator(); again != first {
+		t.Fatal("readiness probe built a new Migrator")
+	}
+}
+
+// unreachableStore fails every ping with the kind of error a driver
+// returns, naming hosts and users.
+type unreachableStore struct {
+	Storage
+}
+
+func (unreachableStore) Ping(context.Context) error {
+	return errors.New(`pq: password authentication failed for user "app" at db.internal:5432`)
+}
+
+func TestReadyzHidesErrors(t *testing.T) {
+	s := newHealthTestServer(t, unreachableStore{NewMemoryStore()})
+	s.migrator = func() (*Migrator, error) {
+		return nil, errors.New("open /srv/go-nba/migrations: permission denied")
+	}
+	rec := httptest.NewRecorder()
+	RequestLogger(s.Router(), nil).ServeHTTP(rec, httptest.NewRequest("GET", "/readyz", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("status = %d, want 503", rec.Code)
+	}
+	for _, leak := range []string{"db.internal", "app", "/srv", "permission"} {
+		if strings.Contains(rec.Body.String(), leak) {
+			t.Errorf("report leaks %q: %s", leak, rec.Body)
+		}
+	}
+	var report HealthReport
+	if err := json.Unmarshal(rec.Body.Bytes(), &report); err != nil {
+		t.Fatal(err)
+	}
+	if got := report.Checks["database"].Detail; got != "database unavailable" {
+		t.Errorf("database detail = %q, want database unavailable", got)
+	}
+	if got := report.Checks["migrations"].Detail; got != "migrations failed" {
+		t.Errorf("migrations detail = %q, want migrations failed", got)
+	}
+}
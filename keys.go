@@ -0,0 +1,231 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+)
+
+// legacyKeyId is used for the HS256 JWT_SECRET and for tokens issued before
+// kid headers existed.
+const legacyKeyId = "legacy"
+
+// defaultTokenTTL matches the default cookie max age, so a remembered login
+// and its token expire together.
+const defaultTokenTTL = 7 * 24 * time.Hour
+
+type SigningKey struct {
+	Id         string
+	Method     jwt.SigningMethod
+	PrivateKey interface{}
+	PublicKey  interface{}
+}
+
+// KeyRing signs with one active key and verifies with every key it holds, so
+// a new key can be rolled out while tokens signed by the old one stay valid.
+// TTL is how long the tokens it issues stay valid.
+type KeyRing struct {
+	TTL    time.Duration
+	mu     sync.RWMutex
+	active string
+	keys   map[string]*SigningKey
+}
+
+func NewKeyRing() *KeyRing {
+	return &KeyRing{TTL: defaultTokenTTL, keys: map[string]*SigningKey{}}
+}
+
+// LoadKeyRing uses the configured secret as the legacy HS256 key and every
+// <kid>.pem in the keys directory as an RS256 or EdDSA key.
+func LoadKeyRing(cfg JWTConfig) (*KeyRing, error) {
+	ring := NewKeyRing()
+	if cfg.TTL > 0 {
+		ring.TTL = cfg.TTL
+	}
+	if secret := cfg.Secret; secret != "" {
+		ring.Add(&SigningKey{
+			Id:         legacyKeyId,
+			Method:     jwt.SigningMethodHS256,
+			PrivateKey: []byte(secret),
+			PublicKey:  []byte(secret),
+		})
+	}
+	if dir := cfg.KeysDir; dir != "" {
+		paths, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+		if err != nil {
+			return nil, err
+		}
+		for _, path := range paths {
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return nil, err
+			}
+			kid := strings.TrimSuffix(filepath.Base(path), ".pem")
+			key, err := ParseSigningKey(kid, data)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", path, err)
+			}
+			ring.Add(key)
+		}
+	}
+	if kid := cfg.ActiveKid; kid != "" {
+		if err := ring.SetActive(kid); err != nil {
+			return nil, err
+		}
+	}
+	if ring.Active() == nil {
+		return nil, fmt.Errorf("no JWT signing key configured")
+	}
+	return ring, nil
+}
+
+func ParseSigningKey(kid string, data []byte) (*SigningKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, jwt.ErrKeyMustBePEMEncoded
+	}
+	var parsed interface{}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		if parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes); err != nil {
+			return nil, fmt.Errorf("unsupported private key")
+		}
+	}
+	switch key := parsed.(type) {
+	case *rsa.PrivateKey:
+		return &SigningKey{Id: kid, Method: jwt.SigningMethodRS256, PrivateKey: key, PublicKey: &key.PublicKey}, nil
+	case ed25519.PrivateKey:
+		return &SigningKey{Id: kid, Method: jwt.SigningMethodEdDSA, PrivateKey: key, PublicKey: key.Public()}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %T", parsed)
+	}
+}
+
+// Add registers a key for verification. The first key added becomes active.
+func (k *KeyRing) Add(key *SigningKey) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.keys[key.Id] = key
+	if k.active == "" {
+		k.active = key.Id
+	}
+}
+
+func (k *KeyRing) SetActive(kid string) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if _, ok := k.keys[kid]; !ok {
+		return fmt.Errorf("unknown signing key %q", kid)
+	}
+	k.active = kid
+	return nil
+}
+
+func (k *KeyRing) Active() *SigningKey {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return k.keys[k.active]
+}
+
+func (k *KeyRing) Get(kid string) (*SigningKey, bool) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	key, ok := k.keys[kid]
+	return key, ok
+}
+
+func (k *KeyRing) Sign(claims jwt.Claims) (string, error) {
+	key := k.Active()
+	if key == nil {
+		return "", fmt.Errorf("no active signing key")
+	}
+	token := jwt.NewWithClaims(key.Method, claims)
+	token.Header["kid"] = key.Id
+	return token.SignedString(key.PrivateKey)
+}
+
+// Parse verifies a token against the key named by its kid. Tokens must carry
+// an exp claim, so one that leaks to a service verifying offline, which
+// cannot see session revocation, still stops working.
+func (k *KeyRing) Parse(tokenStr string) (*jwt.Token, error) {
+	token, err := jwt.Parse(tokenStr, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		if kid == "" {
+			kid = legacyKeyId
+		}
+		key, ok := k.Get(kid)
+		if !ok {
+			return nil, fmt.Errorf("Unknown signing key: %v", kid)
+		}
+		if token.Method.Alg() != key.Method.Alg() {
+			return nil, fmt.Errorf("Unexpected signing method: %v", token.Header["alg"])
+		}
+		return key.PublicKey, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if claims, ok := token.Claims.(jwt.MapClaims); !ok || !claims.VerifyExpiresAt(time.Now().Unix(), true) {
+		return nil, fmt.Errorf("token has no expiry")
+	}
+	return token, nil
+}
+
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS lists the public halves of the asymmetric keys. HMAC secrets are
+// never published.
+func (k *KeyRing) JWKS() JWKS {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	set := JWKS{Keys: []JWK{}}
+	for _, key := range k.keys {
+		switch pub := key.PublicKey.(type) {
+		case *rsa.PublicKey:
+			set.Keys = append(set.Keys, JWK{
+				Kty: "RSA",
+				Kid: key.Id,
+				Use: "sig",
+				Alg: key.Method.Alg(),
+				N:   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+				E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+			})
+		case ed25519.PublicKey:
+			set.Keys = append(set.Keys, JWK{
+				Kty: "OKP",
+				Kid: key.Id,
+				Use: "sig",
+				Alg: key.Method.Alg(),
+				Crv: "Ed25519",
+				X:   base64.RawURLEncoding.EncodeToString(pub),
+			})
+		}
+	}
+	sort.Slice(set.Keys, func(i, j int) bool { return set.Keys[i].Kid < set.Keys[j].Kid })
+	return set
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+)
+
+func pemBlock(t *testing.T, typ string, der []byte, err error) []byte {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der})
+}
+
+func newRSAKey(t *testing.T) *rsa.PrivateKey {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func newEd25519Key(t *testing.T, kid string) *SigningKey {
+	t.Helper()
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &SigningKey{Id: kid, Method: jwt.SigningMethodEdDSA, PrivateKey: priv, PublicKey: priv.Public()}
+}
+
+func validClaims() jwt.MapClaims {
+	return jwt.MapClaims{"accountId": 1, "exp": time.Now().Add(time.Hour).Unix()}
+}
+
+func TestParseSigningKey(t *testing.T) {
+	rsaKey := newRSAKey(t)
+	rsaPKCS8, err := x509.MarshalPKCS8PrivateKey(rsaKey)
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	edPKCS8, edErr := x509.MarshalPKCS8PrivateKey(edKey)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	ecPKCS8, ecErr := x509.MarshalPKCS8PrivateKey(ecKey)
+
+	tests := []struct {
+		name    string
+		data    []byte
+		want    jwt.SigningMethod
+		wantErr bool
+	}{
+		{"RSAPKCS8", pemBlock(t, "PRIVATE KEY", rsaPKCS8, err), jwt.SigningMethodRS256, false},
+		{"RSAPKCS1", pemBlock(t, "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(rsaKey), nil), jwt.SigningMethodRS256, false},
+		{"Ed25519", pemBlock(t, "PRIVATE KEY", edPKCS8, edErr), jwt.SigningMethodEdDSA, false},
+		{"ECDSA", pemBlock(t, "PRIVATE KEY", ecPKCS8, ecErr), nil, true},
+		{"NotPEM", []byte("not a key"), nil, true},
+		{"Garbage", pemBlock(t, "PRIVATE KEY", []byte("garbage"), nil), nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, err := ParseSigningKey("k1", tt.data)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("ParseSigningKey = %+v, want an error", key)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if key.Id != "k1" || key.Method != tt.want {
+				t.Fatalf("key %q uses %s, want k1 using %s", key.Id, key.Method.Alg(), tt.want.Alg())
+			}
+			// The parsed pair must sign and verify.
+			ring := NewKeyRing()
+			ring.Add(key)
+			token, err := ring.Sign(validClaims())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := ring.Parse(token); err != nil {
+				t.Fatalf("Parse of a token it signed: %v", err)
+			}
+		})
+	}
+}
+
+func TestJWKSPublishesOnlyPublicKeys(t *testing.T) {
+	rsaKey := newRSAKey(t)
+	ed := newEd25519Key(t, "b-ed")
+	ring := NewKeyRing()
+	ring.Add(&SigningKey{Id: legacyKeyId, Method: jwt.SigningMethodHS256, PrivateKey: []byte("secret"), PublicKey: []byte("secret")})
+	ring.Add(ed)
+	ring.Add(&SigningKey{Id: "a-rsa", Method: jwt.SigningMethodRS256, PrivateKey: rsaKey, PublicKey: &rsaKey.PublicKey})
+
+	set := ring.JWKS()
+	if len(set.Keys) != 2 {
+		t.Fatalf("got %d keys, want the RSA and Ed25519 keys only: %+v", len(set.Keys), set.Keys)
+	}
+	rsaJWK, edJWK := set.Keys[0], set.Keys[1]
+	if rsaJWK.Kid != "a-rsa" || rsaJWK.Kty != "RSA" || rsaJWK.Alg != "RS256" || rsaJWK.Use != "sig" {
+		t.Errorf("RSA key = %+v", rsaJWK)
+	}
+	n, _ := base64.RawURLEncoding.DecodeString(rsaJWK.N)
+	e, _ := base64.RawURLEncoding.DecodeString(rsaJWK.E)
+	if new(big.Int).SetBytes(n).Cmp(rsaKey.N) != 0 || new(big.Int).SetBytes(e).Int64() != int64(rsaKey.E) {
+		t.Errorf("RSA n/e do not match the public key")
+	}
+	if edJWK.Kid != "b-ed" || edJWK.Kty != "OKP" || edJWK.Crv != "Ed25519" || edJWK.Alg != "EdDSA" {
+		t.Errorf("Ed25519 key = %+v", edJWK)
+	}
+	if x, _ := base64.RawURLEncoding.DecodeString(edJWK.X); string(x) != string(ed.PublicKey.(ed25519.PublicKey)) {
+		t.Errorf("Ed25519 x does not match the public key")
+	}
+}
+
+func TestKeyRingRotation(t *testing.T) {
+	ring := NewKeyRing()
+	ring.Add(newEd25519Key(t, "old"))
+	ring.Add(newEd25519Key(t, "new"))
+	if ring.Active().Id != "old" {
+		t.Fatalf("active = %s, want the first key added", ring.Active().Id)
+	}
+	oldToken, err := ring.Sign(validClaims())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ring.SetActive("missing"); err == nil {
+		t.Fatal("SetActive accepted an unknown kid")
+	}
+	if err := ring.SetActive("new"); err != nil {
+		t.Fatal(err)
+	}
+	newToken, err := ring.Sign(validClaims())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, tokenStr := range map[string]string{"old": oldToken, "new": newToken} {
+		token, err := ring.Parse(tokenStr)
+		if err != nil {
+			t.Fatalf("%s token: %v", name, err)
+		}
+		if kid := token.Header["kid"]; kid != name {
+			t.Errorf("%s token has kid %v", name, kid)
+		}
+	}
+
+	// Once the old key is dropped its tokens no longer verify.
+	retired := NewKeyRing()
+	kept, _ := ring.Get("new")
+	retired.Add(kept)
+	if _, err := retired.Parse(oldToken); err == nil {
+		t.Error("token signed by a removed key verified")
+	}
+}
+
+func TestKeyRingRejectsForgedTokens(t *testing.T) {
+	rsaKey := newRSAKey(t)
+	ring := NewKeyRing()
+	ring.Add(&SigningKey{Id: "rsa", Method: jwt.SigningMethodRS256, PrivateKey: rsaKey, PublicKey: &rsaKey.PublicKey})
+	pubDER, err := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+	pubPEM := pemBlock(t, "PUBLIC KEY", pubDER, err)
+
+	sign := func(method jwt.SigningMethod, kid string, claims jwt.MapClaims, key interface{}) string {
+		t.Helper()
+		token := jwt.NewWithClaims(method, claims)
+		if kid != "" {
+			token.Header["kid"] = kid
+		}
+		s, err := token.SignedString(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+	expired := validClaims()
+	expired["exp"] = time.Now().Add(-time.Minute).Unix()
+	noExpiry := validClaims()
+	delete(noExpiry, "exp")
+
+	tests := []struct {
+		name  string
+		token string
+	}{
+		// HS256 keyed with the published RSA public key.
+		{"AlgConfusion", sign(jwt.SigningMethodHS256, "rsa", validClaims(), pubPEM)},
+		{"NoneAlg", sign(jwt.SigningMethodNone, "rsa", validClaims(), jwt.UnsafeAllowNoneSignatureType)},
+		{"UnknownKid", sign(jwt.SigningMethodRS256, "other", validClaims(), rsaKey)},
+		{"NoKid", sign(jwt.SigningMethodRS256, "", validClaims(), rsaKey)},
+		{"Expired", sign(jwt.SigningMethodRS256, "rsa", expired, rsaKey)},
+		{"NoExpiry", sign(jwt.SigningMethodRS256, "rsa", noExpiry, rsaKey)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if token, err := ring.Parse(tt.token); err == nil {
+				t.Fatalf("Parse accepted %v", token.Header)
+			}
+		})
+	}
+	if _, err := ring.Parse(sign(jwt.SigningMethodRS256, "rsa", validClaims(), rsaKey)); err != nil {
+		t.Fatalf("Parse rejected a valid token: %v", err)
+	}
+}
+
+func TestCreateJWTExpires(t *testing.T) {
+	keys := NewKeyRing()
+	keys.Add(&SigningKey{Id: legacyKeyId, Method: jwt.SigningMethodHS256, PrivateKey: []byte("secret"), PublicKey: []byte("secret")})
+	keys.TTL = time.Hour
+	s := NewAPIServer("", ServerConfig{}, NewMemoryStore(), keys, CookiePolicy{Path: "/"}, map[string]*OIDCProvider{}, LegacyPolicy{})
+	tokenStr, err := s.CreateJWT(&Account{Id: 1, Username: "alice"}, &Session{Id: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := s.ValidateJWT(tokenStr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims := token.Claims.(jwt.MapClaims)
+	iat, _ := claims["iat"].(float64)
+	exp, _ := claims["exp"].(float64)
+	if exp-iat != time.Hour.Seconds() || time.Since(time.Unix(int64(iat), 0)) > time.Minute {
+		t.Errorf("iat %v exp %v, want exp an hour after now", iat, exp)
+	}
+	if _, ok := claims["expiresAt"]; ok {
+		t.Error("token still carries expiresAt")
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// lockoutPolicy allows freeAttempts failures before progressive delays
+// apply and locks the key out for lockout after maxFailures.
+type lockoutPolicy struct {
+	freeAttempts int
+	maxFailures  int
+	lockout      time.Duration
+}
+
+// The IP policy keeps the same proportion of free attempts as the username
+// policy, so a few typos from one user do not slow down everyone sharing
+// their address.
+var (
+	usernameLockoutPolicy = lockoutPolicy{freeAttempts: 2, maxFailures: 5, lockout: 15 * time.Minute}
+	ipLockoutPolicy       = lockoutPolicy{freeAttempts: 8, maxFailures: 20, lockout: 15 * time.Minute}
+)
+
+const (
+	loginBaseDelay = time.Second
+	loginMaxDelay  = 30 * time.Second
+)
+
+// dummyPasswordHash is compared against when the username does not exist so
+// that unknown and known users take the same time to reject.
+var dummyPasswordHash, _ = bcrypt.GenerateFromPassword([]byte("dummy-password"), bcrypt.DefaultCost)
+
+type loginKey struct {
+	key    string
+	policy lockoutPolicy
+}
+
+func usernameLoginKey(username string) loginKey {
+	return loginKey{key: "user:" + strings.ToLower(username), policy: usernameLockoutPolicy}
+}
+
+func ipLoginKey(ip string) loginKey {
+	return loginKey{key: "ip:" + ip, policy: ipLockoutPolicy}
+}
+
+// LoginGuard tracks failed logins in the store so that every instance sees
+// the same counters.
+type LoginGuard struct {
+	store Storage
+	now   func() time.Time
+}
+
+func NewLoginGuard(store Storage) *LoginGuard {
+	return &LoginGuard{store: store, now: time.Now}
+}
+
+// Check returns how long the caller has to wait before another attempt is
+// allowed for any of the keys, or zero if the attempt may proceed.
+func (g *LoginGuard) Check(ctx context.Context, keys ...loginKey) (time.Duration, error) {
+	now := g.now()
+	var wait time.Duration
+	for _, k := range keys {
+		attempt, err := g.store.GetLoginAttempt(ctx, k.key)
+		if err != nil {
+			return 0, err
+		}
+		if d := k.policy.RetryAfter(attempt, now); d > wait {
+			wait = d
+		}
+	}
+	return wait, nil
+}
+
+func (g *LoginGuard) Fail(ctx context.Context, keys ...loginKey) error {
+	now := g.now()
+	for _, k := range keys {
+		attempt, err := g.store.RecordLoginFailure(ctx, k.key, now)
+		if err != nil {
+			return err
+		}
+		if attempt.Failures >= k.policy.maxFailures {
+			if err := g.store.LockLogin(ctx, k.key, now.Add(k.policy.lockout)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Succeed clears the failures of a username that just logged in. The IP
+// counter is left to expire on its own: clearing it would let anyone with
+// one valid account reset their budget between guesses at other accounts.
+func (g *LoginGuard) Succeed(ctx context.Context, username string) error {
+	return g.Reset(ctx, usernameLoginKey(username))
+}
+
+func (g *LoginGuard) Reset(ctx context.Context, keys ...loginKey) error {
+	for _, k := range keys {
+		if err := g.store.ResetLoginAttempts(ctx, k.key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RetryAfter is the remaining lockout, or the progressive delay that doubles
+// with every consecutive failure.
+func (p lockoutPolicy) RetryAfter(a *LoginAttempt, now time.Time) time.Duration {
+	if a.LockedUntil.After(now) {
+		return a.LockedUntil.Sub(now)
+	}
+	if a.Failures <= p.freeAttempts || !a.LockedUntil.IsZero() {
+		return 0
+	}
+	delay := loginBaseDelay
+	for i := p.freeAttempts + 1; i < a.Failures && delay < loginMaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > loginMaxDelay {
+		delay = loginMaxDelay
+	}
+	if next := a.LastFailure.Add(delay); next.After(now) {
+		return next.Sub(now)
+	}
+	return 0
+}
+
+// clientIP is the address RequestLogger resolved, which honours
+// X-Forwarded-For from trusted proxies, or else the peer address.
+func clientIP(r *http.Request) string {
+	if info := requestInfoFrom(r.Context()); info != nil {
+		return info.clientIP
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
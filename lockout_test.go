@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func newTestLoginGuard() (*LoginGuard, *time.Time) {
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	g := NewLoginGuard(NewMemoryStore())
+	g.now = func() time.Time { return now }
+	return g, &now
+}
+
+func TestRetryAfterDelayProgression(t *testing.T) {
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		policy   lockoutPolicy
+		failures int
+		want     time.Duration
+	}{
+		{usernameLockoutPolicy, 0, 0},
+		{usernameLockoutPolicy, 2, 0},
+		{usernameLockoutPolicy, 3, time.Second},
+		{usernameLockoutPolicy, 4, 2 * time.Second},
+		{usernameLockoutPolicy, 5, 4 * time.Second},
+		{usernameLockoutPolicy, 20, loginMaxDelay},
+		{ipLockoutPolicy, 3, 0},
+		{ipLockoutPolicy, 8, 0},
+		{ipLockoutPolicy, 9, time.Second},
+		{ipLockoutPolicy, 10, 2 * time.Second},
+	}
+	for _, tt := range tests {
+		a := &LoginAttempt{Failures: tt.failures, LastFailure: now}
+		if got := tt.policy.RetryAfter(a, now); got != tt.want {
+			t.Errorf("RetryAfter(%+v, %d failures) = %s, want %s", tt.policy, tt.failures, got, tt.want)
+		}
+	}
+}
+
+func TestRetryAfterDelayElapses(t *testing.T) {
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	a := &LoginAttempt{Failures: 4, LastFailure: now}
+	if got := usernameLockoutPolicy.RetryAfter(a, now.Add(500*time.Millisecond)); got != 1500*time.Millisecond {
+		t.Errorf("RetryAfter = %s, want 1.5s", got)
+	}
+	if got := usernameLockoutPolicy.RetryAfter(a, now.Add(2*time.Second)); got != 0 {
+		t.Errorf("RetryAfter after the delay = %s, want 0", got)
+	}
+}
+
+func TestLoginGuardLocksOut(t *testing.T) {
+	ctx := context.Background()
+	g, now := newTestLoginGuard()
+	key := usernameLoginKey("Alice")
+	for i := 0; i < usernameLockoutPolicy.maxFailures; i++ {
+		if err := g.Fail(ctx, key); err != nil {
+			t.Fatal(err)
+		}
+	}
+	wait, err := g.Check(ctx, usernameLoginKey("alice"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wait != usernameLockoutPolicy.lockout {
+		t.Fatalf("wait = %s, want %s", wait, usernameLockoutPolicy.lockout)
+	}
+
+	*now = now.Add(usernameLockoutPolicy.lockout)
+	if wait, err = g.Check(ctx, key); err != nil || wait != 0 {
+		t.Fatalf("after the lockout wait = %s, %v, want 0", wait, err)
+	}
+	if err := g.Fail(ctx, key); err != nil {
+		t.Fatal(err)
+	}
+	if wait, err = g.Check(ctx, key); err != nil || wait != 0 {
+		t.Fatalf("first failure after the lockout wait = %s, %v, want 0", wait, err)
+	}
+}
+
+func TestLoginGuardSharedIPIsNotThrottledEarly(t *testing.T) {
+	ctx := context.Background()
+	g, _ := newTestLoginGuard()
+	ip := ipLoginKey("203.0.113.7")
+	for i := 0; i < usernameLockoutPolicy.freeAttempts+1; i++ {
+		if err := g.Fail(ctx, usernameLoginKey("alice"), ip); err != nil {
+			t.Fatal(err)
+		}
+	}
+	wait, err := g.Check(ctx, usernameLoginKey("bob"), ip)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wait != 0 {
+		t.Fatalf("another user behind the same IP waits %s, want 0", wait)
+	}
+}
+
+func TestLoginGuardReset(t *testing.T) {
+	ctx := context.Background()
+	g, _ := newTestLoginGuard()
+	keys := []loginKey{usernameLoginKey("alice"), ipLoginKey("203.0.113.7")}
+	for i := 0; i < ipLockoutPolicy.maxFailures; i++ {
+		if err := g.Fail(ctx, keys...); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// A successful login clears the username but not the IP.
+	if err := g.Succeed(ctx, "Alice"); err != nil {
+		t.Fatal(err)
+	}
+	if wait, err := g.Check(ctx, keys[0]); err != nil || wait != 0 {
+		t.Errorf("username wait after login = %s, %v, want 0", wait, err)
+	}
+	attempt, err := g.store.GetLoginAttempt(ctx, keys[1].key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attempt.Failures != ipLockoutPolicy.maxFailures {
+		t.Errorf("IP failures after login = %d, want %d", attempt.Failures, ipLockoutPolicy.maxFailures)
+	}
+	if wait, err := g.Check(ctx, keys[1]); err != nil || wait == 0 {
+		t.Fatalf("IP wait after login = %s, %v, want a lockout", wait, err)
+	}
+
+	// An admin unlock clears every key it is given.
+	if err := g.Reset(ctx, keys...); err != nil {
+		t.Fatal(err)
+	}
+	for _, k := range keys {
+		if wait, err := g.Check(ctx, k); err != nil || wait != 0 {
+			t.Errorf("%s wait after reset = %s, %v, want 0", k.key, wait, err)
+		}
+	}
+}
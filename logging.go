@@ -0,0 +1,220 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// NewLogger builds the process logger from the log config.
+func (c LogConfig) NewLogger() (*slog.Logger, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(c.Level)); err != nil {
+		return nil, fmt.Errorf("level: %w", err)
+	}
+	opts := &slog.HandlerOptions{Level: level}
+	switch c.Format {
+	case "text":
+		return slog.New(slog.NewTextHandler(os.Stderr, opts)), nil
+	case "json":
+		return slog.New(slog.NewJSONHandler(os.Stderr, opts)), nil
+	}
+	return nil, fmt.Errorf("format must be text or json, not %q", c.Format)
+}
+
+// Proxies parses the comma separated trusted_proxies list. Bare addresses
+// are treated as single-address prefixes.
+func (c ServerConfig) Proxies() ([]netip.Prefix, error) {
+	prefixes := []netip.Prefix{}
+	for _, s := range strings.Split(c.TrustedProxies, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		if !strings.Contains(s, "/") {
+			addr, err := netip.ParseAddr(s)
+			if err != nil {
+				return nil, err
+			}
+			prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(s)
+		if err != nil {
+			return nil, err
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
+const requestIDHeader = "X-Request-ID"
+
+// probeRoutes are polled by the orchestrator and only logged at debug
+// level, so they do not drown out real traffic.
+var probeRoutes = map[string]bool{"/healthz": true, "/readyz": true, "/metrics": true}
+
+// requestIDPattern limits propagated request IDs to what is safe to echo
+// and log.
+var requestIDPattern = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+// requestInfo is filled in as a request passes through the middleware and
+// handlers, and logged once the response is written.
+type requestInfo struct {
+	id       string
+	clientIP string
+	route    string
+	logger   *slog.Logger
+}
+
+func requestInfoFrom(ctx context.Context) *requestInfo {
+	info, _ := ctx.Value("requestInfo").(*requestInfo)
+	return info
+}
+
+// LoggerFrom returns the request-scoped logger, which tags every record
+// with the request ID, or the default logger outside a request.
+func LoggerFrom(ctx context.Context) *slog.Logger {
+	if info := requestInfoFrom(ctx); info != nil {
+		return info.logger
+	}
+	return slog.Default()
+}
+
+// RequestLogger assigns every request an ID, taken from X-Request-ID when
+// the caller sent a usable one, resolves the client IP and logs one record
+// per request with its route template, status, latency and size. AuthGuard
+// adds the account ID to the request logger.
+func RequestLogger(next http.Handler, proxies []netip.Prefix) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		id := r.Header.Get(requestIDHeader)
+		if !requestIDPattern.MatchString(id) {
+			id = newRequestID()
+		}
+		w.Header().Set(requestIDHeader, id)
+		info := &requestInfo{id: id, clientIP: forwardedFor(r, proxies)}
+		info.logger = slog.Default().With("requestId", id)
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r.WithContext(context.WithValue(r.Context(), "requestInfo", info)))
+
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		level := slog.LevelInfo
+		switch {
+		case probeRoutes[info.route]:
+			level = slog.LevelDebug
+		case rec.status >= 500:
+			level = slog.LevelError
+		}
+		attrs := []any{
+			"method", r.Method,
+			"route", info.route,
+			"status", rec.status,
+			"latency", time.Since(start),
+			"bytes", rec.bytes,
+			"ip", info.clientIP,
+		}
+		info.logger.Log(r.Context(), level, "request", attrs...)
+	})
+}
+
+// recordRoute is router middleware that notes the matched route template,
+// which only exists once mux has matched the request.
+func recordRoute(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if info := requestInfoFrom(r.Context()); info != nil {
+			if route := mux.CurrentRoute(r); route != nil {
+				info.route, _ = route.GetPathTemplate()
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// forwardedFor returns the client address. X-Forwarded-For is only read
+// when the connection comes from a trusted proxy, and then from the right,
+// skipping further trusted proxies, since anything left of them could have
+// been sent by the client.
+func forwardedFor(r *http.Request, proxies []netip.Prefix) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if !trusted(host, proxies) {
+		return host
+	}
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if _, err := netip.ParseAddr(hop); err != nil {
+			break
+		}
+		host = hop
+		if !trusted(hop, proxies) {
+			break
+		}
+	}
+	return host
+}
+
+func trusted(ip string, proxies []netip.Prefix) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, p := range proxies {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// statusRecorder remembers the status and body size of a response.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (s *statusRecorder) WriteHeader(status int) {
+	if s.status == 0 {
+		s.status = status
+	}
+	s.ResponseWriter.WriteHeader(status)
+}
+
+func (s *statusRecorder) Write(p []byte) (int, error) {
+	if s.status == 0 {
+		s.status = http.StatusOK
+	}
+	n, err := s.ResponseWriter.Write(p)
+	s.bytes += n
+	return n, err
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer, e.g. to
+// flush a stream.
+func (s *statusRecorder) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestForwardedFor(t *testing.T) {
+	proxies, err := ServerConfig{TrustedProxies: "10.0.0.0/8, 192.0.2.10"}.Proxies()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name   string
+		remote string
+		xff    []string
+		want   string
+	}{
+		{"no header", "203.0.113.7:4000", nil, "203.0.113.7"},
+		{"untrusted peer", "203.0.113.7:4000", []string{"198.51.100.1"}, "203.0.113.7"},
+		{"trusted peer", "10.1.2.3:4000", []string{"198.51.100.1"}, "198.51.100.1"},
+		{"trusted peer without header", "10.1.2.3:4000", nil, "10.1.2.3"},
+		// The client prepended an address; the proxy appended the real one.
+		{"spoofed leftmost entry", "10.1.2.3:4000", []string{"127.0.0.1, 198.51.100.1"}, "198.51.100.1"},
+		{"multiple trusted hops", "10.1.2.3:4000", []string{"198.51.100.1, 192.0.2.10, 10.9.9.9"}, "198.51.100.1"},
+		{"hops across headers", "10.1.2.3:4000", []string{"6.6.6.6, 198.51.100.1", "10.9.9.9"}, "198.51.100.1"},
+		{"every hop trusted", "10.1.2.3:4000", []string{"10.4.4.4, 10.9.9.9"}, "10.4.4.4"},
+		// Past a garbled hop nothing can be believed, so the last good
+		// address wins.
+		{"garbled hop", "10.1.2.3:4000", []string{"198.51.100.1, unknown, 10.9.9.9"}, "10.9.9.9"},
+		{"ipv6", "[2001:db8::1]:4000", []string{"198.51.100.1"}, "2001:db8::1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.RemoteAddr = tt.remote
+			for _, v := range tt.xff {
+				r.Header.Add("X-Forwarded-For", v)
+			}
+			if got := forwardedFor(r, proxies); got != tt.want {
+				t.Errorf("forwardedFor = %s, want %s", got, tt.want)
+			}
+		})
+	}
+
+	// With no trusted proxies the header is never read.
+	r := httptest.NewRequest("GET", "/", nil)
+	r.RemoteAddr = "10.1.2.3:4000"
+	r.Header.Set("X-Forwarded-For", "198.51.100.1")
+	if got := forwardedFor(r, nil); got != "10.1.2.3" {
+		t.Errorf("forwardedFor without proxies = %s, want 10.1.2.3", got)
+	}
+}
@@ -1,23 +1,207 @@
 package main
 
 import (
+	"context"
+	"fmt"
 	"log"
-
-	"github.com/joho/godotenv"
+	"log/slog"
+	"net"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
 )
 
 func main() {
-	err := godotenv.Load(".env")
+	cfg, args, err := LoadConfig(os.Args[1:])
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(args) > 0 && args[0] == "config" {
+		if len(args) < 2 || args[1] != "print" {
+			log.Fatal("usage: config print")
+		}
+		fmt.Print(cfg)
+		return
+	}
+	store, err := openStorage(cfg.DatabaseURL, cfg.DBTimeout)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(args) > 0 && args[0] == "migrate" {
+		if err := runMigrate(store, args[1:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if len(args) > 0 {
+		log.Fatalf("unknown command %q", args[0])
+	}
+	logger, err := cfg.Log.NewLogger()
+	if err != nil {
+		log.Fatal(err)
+	}
+	slog.SetDefault(logger)
+	slog.Info("loaded config", "config", cfg.String())
+	if err := initStorage(store); err != nil {
+		log.Fatal(err)
+	}
+	cache, err := OpenCache(cfg.Cache.URL, cfg.Cache.Size)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if cache != nil {
+		store = NewCachedStore(store, cache, cfg.Cache.TTL)
+	}
+	keys, err := LoadKeyRing(cfg.JWT)
 	if err != nil {
-		log.Fatal("Error loading .env file")
+		log.Fatal(err)
 	}
-	store, err := NewPostgresStore()
+	cookies, err := cfg.Cookie.Policy()
 	if err != nil {
 		log.Fatal(err)
 	}
-	if err := store.Init(); err != nil {
+	legacy, err := cfg.API.Legacy()
+	if err != nil {
 		log.Fatal(err)
 	}
-	api := NewAPIServer(":3000", store)
-	api.Run()
+	providers := NewOIDCProviders(cfg.OIDC)
+	api := NewAPIServer(cfg.ListenAddr, cfg.Server, store, keys, cookies, providers, legacy)
+	if addr := cfg.OIDC.DevIssuerAddr; addr != "" {
+		issuer, err := NewFakeOIDCIssuer("dev-client")
+		if err != nil {
+			log.Fatal(err)
+		}
+		l, err := net.Listen("tcp", addr)
+		if err != nil {
+			log.Fatal(err)
+		}
+		issuerURL := "http://" + l.Addr().String()
+		providers["dev"] = NewOIDCProvider("dev", issuerURL, "dev-client", "", cfg.OIDC.DevRedirectURL)
+		api.workers.Add("oidc-dev-issuer", func(ctx context.Context) error {
+			return issuer.ServeUntil(ctx, l)
+		})
+		slog.Info("local OIDC issuer running", "url", issuerURL)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	go func() {
+		// A second signal kills the process instead of waiting for the drain.
+		<-ctx.Done()
+		stop()
+	}()
+	err = api.Run(ctx)
+	if closeErr := store.Close(); closeErr != nil {
+		slog.Error("closing storage failed", "err", closeErr)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	slog.Info("stopped")
+}
+
+type migratable interface {
+	Migrator() (*Migrator, error)
+}
+
+// openStorage picks the Storage implementation from the DSN scheme:
+// memory: for a throwaway in-process store, sqlite:<path> for an embedded
+// database, anything else is handed to Postgres. timeout bounds each
+// database operation.
+func openStorage(dsn string, timeout time.Duration) (Storage, error) {
+	switch {
+	case strings.HasPrefix(dsn, "memory:"):
+		return NewMemoryStore(), nil
+	case strings.HasPrefix(dsn, "sqlite:"):
+		path := strings.TrimPrefix(strings.TrimPrefix(dsn, "sqlite:"), "//")
+		store, err := NewSQLiteStore(path)
+		if err != nil {
+			return nil, err
+		}
+		store.timeout = timeout
+		return store, nil
+	default:
+		store, err := NewPostgresStore(dsn)
+		if err != nil {
+			return nil, err
+		}
+		store.timeout = timeout
+		return store, nil
+	}
+}
+
+// initStorage applies pending migrations and seeds the league teams into
+// the embedded backends.
+func initStorage(store Storage) error {
+	if m, ok := store.(migratable); ok {
+		migrator, err := m.Migrator()
+		if err != nil {
+			return err
+		}
+		if err := migrator.Up(); err != nil {
+			return err
+		}
+	}
+	if _, ok := store.(*PostgresStore); ok {
+		return nil
+	}
+	return seedTeams(store)
+}
+
+func runMigrate(store Storage, args []string) error {
+	m, ok := store.(migratable)
+	if !ok {
+		return fmt.Errorf("storage has no migrations")
+	}
+	migrator, err := m.Migrator()
+	if err != nil {
+		return err
+	}
+	if len(args) == 0 {
+		return fmt.Errorf("usage: migrate up|down [steps]|status")
+	}
+	switch args[0] {
+	case "up":
+		return migrator.Up()
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			if steps, err = strconv.Atoi(args[1]); err != nil || steps < 1 {
+				return fmt.Errorf("invalid steps %q", args[1])
+			}
+		}
+		return migrator.Down(steps)
+	case "status":
+		statuses, err := migrator.Status()
+		if err != nil {
+			return err
+		}
+		for _, status := range statuses {
+			applied := "pending"
+			if status.AppliedAt != nil {
+				applied = status.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Printf("%04d  %-35s %s\n", status.Version, status.Name, applied)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown migrate command %q", args[0])
+	}
+}
+
+// seedTeams adds the league teams to an empty store.
+func seedTeams(store Storage) error {
+	ctx := context.Background()
+	teams, err := store.GetTeams(ctx)
+	if err != nil || len(teams) > 0 {
+		return err
+	}
+	for i := range nbaTeams {
+		if err := store.AddTeam(ctx, &nbaTeams[i]); err != nil {
+			return err
+		}
+	}
+	return nil
 }
@@ -0,0 +1,419 @@
+package main
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemoryStore is a concurrency-safe Storage kept entirely in memory. It
+// enforces the same constraints as the Postgres schema: unique usernames
+// and team abbreviations, existing teams for team follows, and deleting an
+// account removes everything that belongs to it. Violations return the
+// same domain errors translateDBError produces for the SQL backends.
+type MemoryStore struct {
+	mu            sync.RWMutex
+	nextAccountId int
+	nextSessionId int
+	accounts      map[int]*Account
+	teams         map[string]*Team
+	teamOrder     []string
+	follows       map[int][]*Follow
+	loginAttempts map[string]*LoginAttempt
+	sessions      map[int]*Session
+	identities    map[string]*Identity
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		nextAccountId: 1,
+		nextSessionId: 1,
+		accounts:      map[int]*Account{},
+		teams:         map[string]*Team{},
+		follows:       map[int][]*Follow{},
+		loginAttempts: map[string]*LoginAttempt{},
+		sessions:      map[int]*Session{},
+		identities:    map[string]*Identity{},
+	}
+}
+
+func identityKey(provider string, subject string) string {
+	return provider + "\x00" + subject
+}
+
+func (s *MemoryStore) CreateAccount(ctx context.Context, acc *Account) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(acc.Username) > 50 {
+		return Invalid("A value is out of range or too long.")
+	}
+	for _, existing := range s.accounts {
+		if existing.Username == acc.Username {
+			return Conflict("Username is already taken.")
+		}
+	}
+	acc.Id = s.nextAccountId
+	acc.CreatedAt = time.Now()
+	acc.UpdatedAt = acc.CreatedAt
+	acc.Version = 1
+	if acc.Timezone == "" {
+		acc.Timezone = "UTC"
+	}
+	if acc.TimeFormat == "" {
+		acc.TimeFormat = "24h"
+	}
+	s.nextAccountId++
+	stored := *acc
+	s.accounts[acc.Id] = &stored
+	return nil
+}
+
+func (s *MemoryStore) DeleteAccount(ctx context.Context, id int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	acc, ok := s.accounts[id]
+	if !ok {
+		return ErrAccountNotFound
+	}
+	delete(s.accounts, id)
+	delete(s.loginAttempts, usernameLoginKey(acc.Username).key)
+	delete(s.follows, id)
+	for sid, session := range s.sessions {
+		if session.AccountId == id {
+			delete(s.sessions, sid)
+		}
+	}
+	for key, identity := range s.identities {
+		if identity.AccountId == id {
+			delete(s.identities, key)
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) UpdateAccount(ctx context.Context, acc *Account) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stored, ok := s.accounts[acc.Id]
+	if !ok {
+		return ErrAccountNotFound
+	}
+	if stored.Version != acc.Version {
+		return ErrStaleAccount
+	}
+	if len(acc.Username) > 50 {
+		return Invalid("A value is out of range or too long.")
+	}
+	for id, existing := range s.accounts {
+		if id != acc.Id && existing.Username == acc.Username {
+			return Conflict("Username is already taken.")
+		}
+	}
+	acc.Version++
+	acc.UpdatedAt = time.Now()
+	updated := *acc
+	s.accounts[acc.Id] = &updated
+	return nil
+}
+
+func (s *MemoryStore) GetAccountById(ctx context.Context, id int) (*Account, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	acc, ok := s.accounts[id]
+	if !ok {
+		return nil, ErrAccountNotFound
+	}
+	copied := *acc
+	return &copied, nil
+}
+
+func (s *MemoryStore) GetAccountByUsername(ctx context.Context, username string) (*Account, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, acc := range s.accounts {
+		if acc.Username == username {
+			copied := *acc
+			return &copied, nil
+		}
+	}
+	return nil, ErrAccountNotFound
+}
+
+func (s *MemoryStore) AddTeam(ctx context.Context, team *Team) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.teams[team.Abbr]; ok {
+		return Conflict("Team already exists.")
+	}
+	if len(team.Abbr) > 3 {
+		return Invalid("A value is out of range or too long.")
+	}
+	stored := *team
+	s.teams[team.Abbr] = &stored
+	s.teamOrder = append(s.teamOrder, team.Abbr)
+	return nil
+}
+
+func (s *MemoryStore) GetTeams(ctx context.Context) ([]*Team, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	teams := []*Team{}
+	for _, abbr := range s.teamOrder {
+		copied := *s.teams[abbr]
+		teams = append(teams, &copied)
+	}
+	return teams, nil
+}
+
+// checkFollow mirrors the SQL constraints on follows. Callers hold s.mu.
+func (s *MemoryStore) checkFollow(accountId int, target FollowTarget) error {
+	if _, ok := s.accounts[accountId]; !ok {
+		return Invalid("A referenced resource does not exist.")
+	}
+	if _, ok := s.teams[target.Ref]; target.Kind == FollowTeam && !ok {
+		return NotFound("Unknown team %q.", target.Ref)
+	}
+	return nil
+}
+
+func (s *MemoryStore) Follow(ctx context.Context, accountId int, follow *Follow) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.checkFollow(accountId, follow.FollowTarget); err != nil {
+		return false, err
+	}
+	// Like the SQL backends, a new follow goes after the highest position of
+	// its kind, so unfollowing leaves a gap instead of renumbering.
+	position := 0
+	for _, existing := range s.follows[accountId] {
+		if existing.FollowTarget == follow.FollowTarget {
+			return false, nil
+		}
+		if existing.Kind == follow.Kind && existing.Position > position {
+			position = existing.Position
+		}
+	}
+	stored := &Follow{FollowTarget: follow.FollowTarget, Position: position + 1, Notifications: follow.Notifications, CreatedAt: time.Now()}
+	s.follows[accountId] = append(s.follows[accountId], stored)
+	return true, nil
+}
+
+func (s *MemoryStore) Unfollow(ctx context.Context, accountId int, target FollowTarget) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	follows := s.follows[accountId]
+	for i, existing := range follows {
+		if existing.FollowTarget == target {
+			s.follows[accountId] = append(follows[:i:i], follows[i+1:]...)
+			return nil
+		}
+	}
+	return ErrFollowNotFound
+}
+
+func (s *MemoryStore) GetFollows(ctx context.Context, accountId int, kind FollowKind, page *PageRequest) ([]*Follow, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	follows := []*Follow{}
+	for _, follow := range s.follows[accountId] {
+		if kind != "" && follow.Kind != kind {
+			continue
+		}
+		copied := *follow
+		if team, ok := s.teams[follow.Ref]; ok && follow.Kind == FollowTeam {
+			copiedTeam := *team
+			copied.Team = &copiedTeam
+		}
+		follows = append(follows, &copied)
+	}
+	return paginate(follows, page), nil
+}
+
+func (s *MemoryStore) ReplaceFollows(ctx context.Context, accountId int, kind FollowKind, follows []*Follow) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	replaced := []*Follow{}
+	for _, existing := range s.follows[accountId] {
+		if existing.Kind != kind {
+			replaced = append(replaced, existing)
+		}
+	}
+	seen := map[string]bool{}
+	now := time.Now()
+	for i, follow := range follows {
+		target := FollowTarget{Kind: kind, Ref: follow.Ref}
+		if err := s.checkFollow(accountId, target); err != nil {
+			return err
+		}
+		if seen[follow.Ref] {
+			return Conflict("Already following it.")
+		}
+		seen[follow.Ref] = true
+		replaced = append(replaced, &Follow{FollowTarget: target, Position: i + 1, Notifications: follow.Notifications, CreatedAt: now})
+	}
+	s.follows[accountId] = replaced
+	return nil
+}
+
+func (s *MemoryStore) GetLoginAttempt(ctx context.Context, key string) (*LoginAttempt, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	attempt, ok := s.loginAttempts[key]
+	if !ok {
+		return &LoginAttempt{Key: key}, nil
+	}
+	copied := *attempt
+	return &copied, nil
+}
+
+func (s *MemoryStore) RecordLoginFailure(ctx context.Context, key string, at time.Time) (*LoginAttempt, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	attempt, ok := s.loginAttempts[key]
+	if !ok {
+		attempt = &LoginAttempt{Key: key}
+		s.loginAttempts[key] = attempt
+	}
+	if !attempt.LockedUntil.IsZero() && !attempt.LockedUntil.After(at) {
+		attempt.Failures = 0
+		attempt.LockedUntil = time.Time{}
+	}
+	attempt.Failures++
+	attempt.LastFailure = at
+	copied := *attempt
+	return &copied, nil
+}
+
+func (s *MemoryStore) LockLogin(ctx context.Context, key string, until time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if attempt, ok := s.loginAttempts[key]; ok {
+		attempt.LockedUntil = until
+	}
+	return nil
+}
+
+func (s *MemoryStore) ResetLoginAttempts(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.loginAttempts, key)
+	return nil
+}
+
+func (s *MemoryStore) CreateSession(ctx context.Context, session *Session) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.accounts[session.AccountId]; !ok {
+		return Invalid("A referenced resource does not exist.")
+	}
+	session.Id = s.nextSessionId
+	s.nextSessionId++
+	stored := *session
+	s.sessions[session.Id] = &stored
+	return nil
+}
+
+func (s *MemoryStore) GetSessionById(ctx context.Context, id int) (*Session, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	session, ok := s.sessions[id]
+	if !ok {
+		return nil, ErrSessionNotFound
+	}
+	copied := *session
+	return &copied, nil
+}
+
+func (s *MemoryStore) GetAccountSessions(ctx context.Context, accountId int, page *PageRequest) ([]*Session, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	sessions := []*Session{}
+	for _, session := range s.sessions {
+		if session.AccountId == accountId && !session.Revoked {
+			copied := *session
+			sessions = append(sessions, &copied)
+		}
+	}
+	return paginate(sessions, page), nil
+}
+
+func (s *MemoryStore) GetAllAccountSessions(ctx context.Context, accountId int) ([]*Session, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	sessions := []*Session{}
+	for _, session := range s.sessions {
+		if session.AccountId == accountId {
+			copied := *session
+			sessions = append(sessions, &copied)
+		}
+	}
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].Id < sessions[j].Id })
+	return sessions, nil
+}
+
+func (s *MemoryStore) TouchSession(ctx context.Context, id int, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if session, ok := s.sessions[id]; ok {
+		session.LastSeenAt = at
+	}
+	return nil
+}
+
+func (s *MemoryStore) RevokeSession(ctx context.Context, accountId int, id int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	session, ok := s.sessions[id]
+	if !ok || session.AccountId != accountId || session.Revoked {
+		return ErrSessionNotFound
+	}
+	session.Revoked = true
+	return nil
+}
+
+func (s *MemoryStore) GetAccountByIdentity(ctx context.Context, provider string, subject string) (*Account, error) {
+	s.mu.RLock()
+	identity, ok := s.identities[identityKey(provider, subject)]
+	s.mu.RUnlock()
+	if !ok {
+		return nil, ErrAccountNotFound
+	}
+	return s.GetAccountById(ctx, identity.AccountId)
+}
+
+func (s *MemoryStore) LinkIdentity(ctx context.Context, accountId int, provider string, subject string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.accounts[accountId]; !ok {
+		return Invalid("A referenced resource does not exist.")
+	}
+	key := identityKey(provider, subject)
+	if _, ok := s.identities[key]; ok {
+		return Conflict("Identity is already linked to an account.")
+	}
+	s.identities[key] = &Identity{AccountId: accountId, Provider: provider, Subject: subject, CreatedAt: time.Now()}
+	return nil
+}
+
+func (s *MemoryStore) GetAccountIdentities(ctx context.Context, accountId int) ([]*Identity, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	identities := []*Identity{}
+	for _, identity := range s.identities {
+		if identity.AccountId == accountId {
+			copied := *identity
+			identities = append(identities, &copied)
+		}
+	}
+	sort.Slice(identities, func(i, j int) bool { return identities[i].CreatedAt.Before(identities[j].CreatedAt) })
+	return identities, nil
+}
+
+func (s *MemoryStore) Ping(ctx context.Context) error {
+	return nil
+}
+
+func (s *MemoryStore) Close() error {
+	return nil
+}
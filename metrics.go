@@ -0,0 +1,257 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Metrics holds the counters and histograms served at /metrics in the
+// Prometheus text exposition format. Gauges such as pool and cache stats
+// are read when scraped instead.
+type Metrics struct {
+	requests *metricVec
+	latency  *metricVec
+	logins   *metricVec
+}
+
+// latencyBuckets are upper bounds in seconds, from a cached GET to a slow
+// bcrypt login.
+var latencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+func NewMetrics() *Metrics {
+	return &Metrics{
+		requests: newMetricVec("http_requests_total", "HTTP requests by route template and status.", "counter", nil, "method", "route", "status"),
+		latency:  newMetricVec("http_request_duration_seconds", "HTTP request latency by route template and status.", "histogram", latencyBuckets, "method", "route", "status"),
+		logins:   newMetricVec("logins_total", "Login attempts by method and result.", "counter", nil, "method", "result"),
+	}
+}
+
+// Instrument counts and times every request that reaches next. The route
+// template comes from recordRoute, so it must run inside RequestLogger.
+func (m *Metrics) Instrument(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		route := "unmatched"
+		if info := requestInfoFrom(r.Context()); info != nil && info.route != "" {
+			route = info.route
+		}
+		method := metricMethod(r.Method)
+		status := strconv.Itoa(rec.status)
+		m.requests.add(1, method, route, status)
+		m.latency.observe(time.Since(start).Seconds(), method, route, status)
+	})
+}
+
+// metricMethod folds methods outside the standard set into "other", so
+// clients cannot create a time series per made-up method.
+func metricMethod(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+		http.MethodDelete, http.MethodConnect, http.MethodOptions, http.MethodTrace:
+		return method
+	}
+	return "other"
+}
+
+// countLogin records the outcome of a login handler by the error it
+// returned: wrong credentials are failures, lockouts are throttled and
+// anything else, such as a malformed body, is an error.
+func (m *Metrics) countLogin(method string, err error) {
+	result := "success"
+	var e *Error
+	switch {
+	case err == nil:
+	case errors.As(err, &e) && e.Kind == KindUnauthorized:
+		result = "failure"
+	case errors.As(err, &e) && e.Kind == KindTooManyRequests:
+		result = "throttled"
+	default:
+		result = "error"
+	}
+	m.logins.add(1, method, result)
+}
+
+func (s *APIServer) handleMetrics(w http.ResponseWriter, r *http.Request) error {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	s.metrics.requests.write(w)
+	s.metrics.latency.write(w)
+	s.metrics.logins.write(w)
+
+	store := s.store
+	if cached, ok := store.(*CachedStore); ok {
+		stats := cached.Stats.Snapshot()
+		writeHeader(w, "cache_requests_total", "Storage cache lookups by result.", "counter")
+		fmt.Fprintf(w, "cache_requests_total{result=\"hit\"} %d\n", stats.Hits)
+		fmt.Fprintf(w, "cache_requests_total{result=\"miss\"} %d\n", stats.Misses)
+		writeHeader(w, "cache_errors_total", "Storage cache backend failures.", "counter")
+		fmt.Fprintf(w, "cache_errors_total %d\n", stats.Errors)
+		store = cached.Storage
+	}
+	if db, ok := store.(interface{ DBStats() sql.DBStats }); ok {
+		writeDBStats(w, db.DBStats())
+	}
+
+	running := s.workers.Running()
+	names := make([]string, 0, len(running))
+	for name := range running {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	writeHeader(w, "worker_up", "Whether each background worker is running.", "gauge")
+	for _, name := range names {
+		up := 0
+		if running[name] {
+			up = 1
+		}
+		fmt.Fprintf(w, "worker_up{worker=%s} %d\n", quoteLabel(name), up)
+	}
+	return nil
+}
+
+func writeDBStats(w io.Writer, stats sql.DBStats) {
+	gauges := []struct {
+		name, help string
+		value      float64
+	}{
+		{"db_open_connections", "Open database connections, in use and idle.", float64(stats.OpenConnections)},
+		{"db_in_use_connections", "Database connections currently in use.", float64(stats.InUse)},
+		{"db_idle_connections", "Idle database connections.", float64(stats.Idle)},
+		{"db_max_open_connections", "Maximum number of open database connections.", float64(stats.MaxOpenConnections)},
+	}
+	for _, g := range gauges {
+		writeHeader(w, g.name, g.help, "gauge")
+		fmt.Fprintf(w, "%s %s\n", g.name, formatFloat(g.value))
+	}
+	counters := []struct {
+		name, help string
+		value      float64
+	}{
+		{"db_wait_count_total", "Connections waited for because the pool was exhausted.", float64(stats.WaitCount)},
+		{"db_wait_duration_seconds_total", "Time spent waiting for a connection.", stats.WaitDuration.Seconds()},
+		{"db_max_idle_closed_total", "Connections closed because of max_idle_conns.", float64(stats.MaxIdleClosed)},
+		{"db_max_lifetime_closed_total", "Connections closed because of conn_max_lifetime.", float64(stats.MaxLifetimeClosed)},
+	}
+	for _, c := range counters {
+		writeHeader(w, c.name, c.help, "counter")
+		fmt.Fprintf(w, "%s %s\n", c.name, formatFloat(c.value))
+	}
+}
+
+// DBStats reports the connection pool of the database.
+func (s *sqlStore) DBStats() sql.DBStats {
+	return s.db.Stats()
+}
+
+// metricVec is a counter or histogram family keyed by its label values.
+type metricVec struct {
+	name    string
+	help    string
+	kind    string
+	buckets []float64
+	labels  []string
+	mu      sync.Mutex
+	series  map[string]*series
+}
+
+type series struct {
+	values []string
+	count  uint64
+	sum    float64
+	counts []uint64
+}
+
+func newMetricVec(name string, help string, kind string, buckets []float64, labels ...string) *metricVec {
+	return &metricVec{name: name, help: help, kind: kind, buckets: buckets, labels: labels, series: map[string]*series{}}
+}
+
+func (m *metricVec) get(values []string) *series {
+	key := strings.Join(values, "\xff")
+	s, ok := m.series[key]
+	if !ok {
+		s = &series{values: values, counts: make([]uint64, len(m.buckets))}
+		m.series[key] = s
+	}
+	return s
+}
+
+func (m *metricVec) add(n float64, values ...string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s := m.get(values)
+	s.count++
+	s.sum += n
+}
+
+func (m *metricVec) observe(v float64, values ...string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s := m.get(values)
+	s.count++
+	s.sum += v
+	for i, bound := range m.buckets {
+		if v <= bound {
+			s.counts[i]++
+		}
+	}
+}
+
+func (m *metricVec) write(w io.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	writeHeader(w, m.name, m.help, m.kind)
+	keys := make([]string, 0, len(m.series))
+	for key := range m.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		s := m.series[key]
+		labels := m.labelPairs(s.values)
+		if m.kind == "counter" {
+			fmt.Fprintf(w, "%s{%s} %s\n", m.name, labels, formatFloat(s.sum))
+			continue
+		}
+		for i, bound := range m.buckets {
+			fmt.Fprintf(w, "%s_bucket{%s,le=\"%s\"} %d\n", m.name, labels, formatFloat(bound), s.counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket{%s,le=\"+Inf\"} %d\n", m.name, labels, s.count)
+		fmt.Fprintf(w, "%s_sum{%s} %s\n", m.name, labels, formatFloat(s.sum))
+		fmt.Fprintf(w, "%s_count{%s} %d\n", m.name, labels, s.count)
+	}
+}
+
+func (m *metricVec) labelPairs(values []string) string {
+	pairs := make([]string, len(m.labels))
+	for i, label := range m.labels {
+		pairs[i] = label + "=" + quoteLabel(values[i])
+	}
+	return strings.Join(pairs, ",")
+}
+
+func writeHeader(w io.Writer, name string, help string, kind string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+// quoteLabel escapes a label value as the exposition format requires.
+func quoteLabel(v string) string {
+	v = strings.ReplaceAll(v, `\`, `\\`)
+	v = strings.ReplaceAll(v, "\n", `\n`)
+	return `"` + strings.ReplaceAll(v, `"`, `\"`) + `"`
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
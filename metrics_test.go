@@ -0,0 +1,30 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestInstrumentFoldsUnknownMethods(t *testing.T) {
+	m := NewMetrics()
+	h := RequestLogger(m.Instrument(http.NotFoundHandler()), nil)
+	for _, method := range []string{"GET", "BREW", "X-RANDOM-1", "X-RANDOM-2"} {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(method, "/nowhere", nil))
+	}
+
+	var out strings.Builder
+	m.requests.write(&out)
+	for _, want := range []string{
+		`http_requests_total{method="GET",route="unmatched",status="404"} 1`,
+		`http_requests_total{method="other",route="unmatched",status="404"} 3`,
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("metrics missing %s in:\n%s", want, out.String())
+		}
+	}
+	if strings.Contains(out.String(), "BREW") {
+		t.Errorf("metrics keep the raw method:\n%s", out.String())
+	}
+}
@@ -2,62 +2,96 @@ package main
 
 import (
 	"context"
-	"fmt"
 	"net/http"
-	"os"
+	"time"
 
 	"github.com/golang-jwt/jwt"
 )
 
-func CreateJWT(acc *Account) (string, error) {
+// sessionTouchInterval limits how often last_seen_at is written per session.
+const sessionTouchInterval = time.Minute
+
+func (s *APIServer) CreateJWT(acc *Account, session *Session) (string, error) {
+	now := time.Now()
 	claims := &jwt.MapClaims{
-		"expiresAt": 15000,
+		"iat":       now.Unix(),
+		"exp":       now.Add(s.keys.TTL).Unix(),
 		"accountId": acc.Id,
 		"username":  acc.Username,
+		"sessionId": session.Id,
 	}
-	secret := os.Getenv("JWT_SECRET")
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString([]byte(secret))
+	return s.keys.Sign(claims)
 }
 
-func ValidateJWT(token string) (*jwt.Token, error) {
-	secret := os.Getenv("JWT_SECRET")
-	return jwt.Parse(token, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("Unexpected signing method: %v", token.Header["alg"])
-		}
-
-		return []byte(secret), nil
-	})
+func (s *APIServer) ValidateJWT(token string) (*jwt.Token, error) {
+	return s.keys.Parse(token)
 }
 
-func PermissionDenied(w http.ResponseWriter) {
-	WriteJSON(w, http.StatusUnauthorized, ApiError{Error: "Permission denied."})
+func PermissionDenied(w http.ResponseWriter, r *http.Request) {
+	WriteError(w, r, Unauthorized("Permission denied."))
 }
 
-func AuthGuard(f http.HandlerFunc) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
+// authenticate resolves the bearer token or token cookie to a live session.
+func (s *APIServer) authenticate(r *http.Request) (*Session, bool) {
+	tokenStr, ok := bearerToken(r)
+	if !ok {
 		t, err := r.Cookie("token")
 		if err != nil {
-			PermissionDenied(w)
-			return
+			return nil, false
 		}
-		token, err := ValidateJWT(t.Value)
-		if err != nil {
-			PermissionDenied(w)
+		tokenStr = t.Value
+	}
+	token, err := s.ValidateJWT(tokenStr)
+	if err != nil || !token.Valid {
+		return nil, false
+	}
+	claims := token.Claims.(jwt.MapClaims)
+	accountId, ok := claims["accountId"].(float64)
+	if !ok {
+		return nil, false
+	}
+	sessionId, ok := claims["sessionId"].(float64)
+	if !ok {
+		return nil, false
+	}
+	session, err := s.store.GetSessionById(r.Context(), int(sessionId))
+	if err != nil || session.Revoked || session.AccountId != int(accountId) {
+		return nil, false
+	}
+	if now := time.Now(); now.Sub(session.LastSeenAt) > sessionTouchInterval {
+		s.store.TouchSession(r.Context(), session.Id, now)
+	}
+	return session, true
+}
+
+func (s *APIServer) AuthGuard(f http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		session, ok := s.authenticate(r)
+		if !ok {
+			PermissionDenied(w, r)
 			return
 		}
-		if !token.Valid {
-			PermissionDenied(w)
-			return
+		if info := requestInfoFrom(r.Context()); info != nil {
+			info.logger = info.logger.With("accountId", session.AccountId)
 		}
-		claims := token.Claims.(jwt.MapClaims)
-		accountId, ok := claims["accountId"]
+		ctx := context.WithValue(r.Context(), "accountId", session.AccountId)
+		ctx = context.WithValue(ctx, "sessionId", session.Id)
+		f(w, r.WithContext(ctx))
+	}
+}
+
+func (s *APIServer) AdminGuard(f http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		accountId, ok := r.Context().Value("accountId").(int)
 		if !ok {
-			PermissionDenied(w)
+			PermissionDenied(w, r)
 			return
 		}
-		ctx := context.WithValue(r.Context(), "accountId", int(accountId.(float64)))
-		f(w, r.WithContext(ctx))
+		acc, err := s.store.GetAccountById(r.Context(), accountId)
+		if err != nil || !acc.IsAdmin {
+			WriteError(w, r, Forbidden("Forbidden."))
+			return
+		}
+		f(w, r)
 	}
 }
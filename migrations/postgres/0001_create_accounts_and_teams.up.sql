@@ -0,0 +1,20 @@
+create table if not exists accounts (
+    id SERIAL PRIMARY KEY,
+    username varchar(50) UNIQUE,
+    encrypted_password varchar(100),
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+create table if not exists teams (
+    id SERIAL PRIMARY KEY,
+    name varchar(50),
+    abbr varchar(3) UNIQUE
+);
+
+create table if not exists account_teams (
+    account_id INT,
+    team_abbr varchar(3),
+    PRIMARY KEY(account_id, team_abbr),
+    FOREIGN KEY(account_id) REFERENCES accounts(id),
+    FOREIGN KEY(team_abbr) REFERENCES teams(abbr)
+);
@@ -0,0 +1 @@
+alter table accounts add column if not exists is_admin boolean not null default false;
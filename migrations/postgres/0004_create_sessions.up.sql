@@ -0,0 +1,10 @@
+create table if not exists sessions (
+    id SERIAL PRIMARY KEY,
+    account_id INT NOT NULL,
+    user_agent varchar(255),
+    ip varchar(64),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    last_seen_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    revoked_at TIMESTAMPTZ,
+    FOREIGN KEY(account_id) REFERENCES accounts(id) ON DELETE CASCADE
+);
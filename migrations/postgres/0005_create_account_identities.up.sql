@@ -0,0 +1,8 @@
+create table if not exists account_identities (
+    provider varchar(50),
+    subject varchar(255),
+    account_id INT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY(provider, subject),
+    FOREIGN KEY(account_id) REFERENCES accounts(id) ON DELETE CASCADE
+);
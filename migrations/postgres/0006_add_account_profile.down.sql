@@ -0,0 +1,4 @@
+alter table accounts drop column if exists version;
+alter table accounts drop column if exists updated_at;
+alter table accounts drop column if exists time_format;
+alter table accounts drop column if exists timezone;
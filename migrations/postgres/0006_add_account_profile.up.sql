@@ -0,0 +1,4 @@
+alter table accounts add column if not exists timezone varchar(64) not null default 'UTC';
+alter table accounts add column if not exists time_format varchar(3) not null default '24h';
+alter table accounts add column if not exists updated_at TIMESTAMPTZ;
+alter table accounts add column if not exists version INT not null default 1;
@@ -0,0 +1,8 @@
+alter table account_teams add column position INT not null default 0;
+alter table account_teams add column notify_tip_off BOOLEAN not null default false;
+alter table account_teams add column notify_final_score BOOLEAN not null default false;
+alter table account_teams add column notify_schedule_change BOOLEAN not null default false;
+update account_teams set position = (
+    select count(*) from account_teams t
+    where t.account_id = account_teams.account_id and t.team_abbr <= account_teams.team_abbr
+);
@@ -0,0 +1,17 @@
+create table if not exists account_teams (
+    account_id INT,
+    team_abbr varchar(3),
+    position INT NOT NULL DEFAULT 0,
+    notify_tip_off BOOLEAN NOT NULL DEFAULT false,
+    notify_final_score BOOLEAN NOT NULL DEFAULT false,
+    notify_schedule_change BOOLEAN NOT NULL DEFAULT false,
+    PRIMARY KEY(account_id, team_abbr),
+    FOREIGN KEY(account_id) REFERENCES accounts(id),
+    FOREIGN KEY(team_abbr) REFERENCES teams(abbr)
+);
+
+insert into account_teams (account_id, team_abbr, position, notify_tip_off, notify_final_score, notify_schedule_change)
+select account_id, ref, position, notify_tip_off, notify_final_score, notify_schedule_change
+from follows where kind = 'team';
+
+drop table follows;
@@ -0,0 +1,18 @@
+create table if not exists follows (
+    account_id INT NOT NULL,
+    kind varchar(10) NOT NULL CHECK(kind in ('team', 'game', 'player')),
+    ref varchar(64) NOT NULL,
+    position INT NOT NULL DEFAULT 0,
+    notify_tip_off BOOLEAN NOT NULL DEFAULT false,
+    notify_final_score BOOLEAN NOT NULL DEFAULT false,
+    notify_schedule_change BOOLEAN NOT NULL DEFAULT false,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY(account_id, kind, ref),
+    FOREIGN KEY(account_id) REFERENCES accounts(id) ON DELETE CASCADE
+);
+
+insert into follows (account_id, kind, ref, position, notify_tip_off, notify_final_score, notify_schedule_change)
+select account_id, 'team', team_abbr, position, notify_tip_off, notify_final_score, notify_schedule_change
+from account_teams;
+
+drop table account_teams;
@@ -0,0 +1,3 @@
+drop table if exists account_teams;
+drop table if exists teams;
+drop table if exists accounts;
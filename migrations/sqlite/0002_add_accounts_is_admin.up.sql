@@ -0,0 +1 @@
+alter table accounts add column is_admin boolean not null default false;
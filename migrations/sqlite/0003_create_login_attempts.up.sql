@@ -0,0 +1,6 @@
+create table if not exists login_attempts (
+    key varchar(100) PRIMARY KEY,
+    failures INT NOT NULL DEFAULT 0,
+    last_failure TIMESTAMP,
+    locked_until TIMESTAMP
+);
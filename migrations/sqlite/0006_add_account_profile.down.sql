@@ -0,0 +1,4 @@
+alter table accounts drop column version;
+alter table accounts drop column updated_at;
+alter table accounts drop column time_format;
+alter table accounts drop column timezone;
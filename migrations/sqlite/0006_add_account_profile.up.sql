@@ -0,0 +1,4 @@
+alter table accounts add column timezone varchar(64) not null default 'UTC';
+alter table accounts add column time_format varchar(3) not null default '24h';
+alter table accounts add column updated_at TIMESTAMP;
+alter table accounts add column version INT not null default 1;
@@ -0,0 +1,4 @@
+alter table account_teams drop column notify_schedule_change;
+alter table account_teams drop column notify_final_score;
+alter table account_teams drop column notify_tip_off;
+alter table account_teams drop column position;
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+)
+
+// FakeOIDCIssuer is a minimal in-process OpenID provider for local
+// development and tests. Every authorization request is approved straight
+// away for the subject passed as login_hint, so no real Google or GitHub
+// account is needed.
+type FakeOIDCIssuer struct {
+	ClientID string
+	keys     *KeyRing
+	mu       sync.Mutex
+	codes    map[string]fakeAuthCode
+	mux      *http.ServeMux
+}
+
+type fakeAuthCode struct {
+	clientId    string
+	redirectURI string
+	nonce       string
+	challenge   string
+	subject     string
+	expiresAt   time.Time
+}
+
+func NewFakeOIDCIssuer(clientID string) (*FakeOIDCIssuer, error) {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	keys := NewKeyRing()
+	keys.Add(&SigningKey{Id: "fake-1", Method: jwt.SigningMethodEdDSA, PrivateKey: priv, PublicKey: priv.Public()})
+	i := &FakeOIDCIssuer{
+		ClientID: clientID,
+		keys:     keys,
+		codes:    map[string]fakeAuthCode{},
+		mux:      http.NewServeMux(),
+	}
+	i.mux.HandleFunc("/.well-known/openid-configuration", i.handleDiscovery)
+	i.mux.HandleFunc("/authorize", i.handleAuthorize)
+	i.mux.HandleFunc("/token", i.handleToken)
+	i.mux.HandleFunc("/jwks", i.handleJWKS)
+	return i, nil
+}
+
+// ServeUntil serves the issuer on l until ctx is cancelled.
+func (i *FakeOIDCIssuer) ServeUntil(ctx context.Context, l net.Listener) error {
+	srv := &http.Server{Handler: i, ReadHeaderTimeout: 5 * time.Second}
+	go func() {
+		<-ctx.Done()
+		srv.Close()
+	}()
+	if err := srv.Serve(l); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+func (i *FakeOIDCIssuer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	i.mux.ServeHTTP(w, r)
+}
+
+func issuerURL(r *http.Request) string {
+	return "http://" + r.Host
+}
+
+func (i *FakeOIDCIssuer) handleDiscovery(w http.ResponseWriter, r *http.Request) {
+	base := issuerURL(r)
+	WriteJSON(w, http.StatusOK, oidcDiscovery{
+		Issuer:                base,
+		AuthorizationEndpoint: base + "/authorize",
+		TokenEndpoint:         base + "/token",
+		JwksURI:               base + "/jwks",
+	})
+}
+
+func (i *FakeOIDCIssuer) handleJWKS(w http.ResponseWriter, r *http.Request) {
+	WriteJSON(w, http.StatusOK, i.keys.JWKS())
+}
+
+func (i *FakeOIDCIssuer) handleAuthorize(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if q.Get("client_id") != i.ClientID || q.Get("response_type") != "code" || q.Get("code_challenge_method") != "S256" {
+		writeOAuthError(w, http.StatusBadRequest, "invalid_request")
+		return
+	}
+	redirect, err := url.Parse(q.Get("redirect_uri"))
+	if err != nil || redirect.Scheme == "" {
+		writeOAuthError(w, http.StatusBadRequest, "invalid_request")
+		return
+	}
+	subject := q.Get("login_hint")
+	if subject == "" {
+		subject = "dev-user"
+	}
+	code, err := randomString(16)
+	if err != nil {
+		writeOAuthError(w, http.StatusInternalServerError, "server_error")
+		return
+	}
+	i.mu.Lock()
+	i.codes[code] = fakeAuthCode{
+		clientId:    i.ClientID,
+		redirectURI: redirect.String(),
+		nonce:       q.Get("nonce"),
+		challenge:   q.Get("code_challenge"),
+		subject:     subject,
+		expiresAt:   time.Now().Add(time.Minute),
+	}
+	i.mu.Unlock()
+	rq := redirect.Query()
+	rq.Set("code", code)
+	rq.Set("state", q.Get("state"))
+	redirect.RawQuery = rq.Encode()
+	http.Redirect(w, r, redirect.String(), http.StatusFound)
+}
+
+func (i *FakeOIDCIssuer) handleToken(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" || r.ParseForm() != nil || r.PostForm.Get("grant_type") != "authorization_code" {
+		writeOAuthError(w, http.StatusBadRequest, "invalid_request")
+		return
+	}
+	i.mu.Lock()
+	code, ok := i.codes[r.PostForm.Get("code")]
+	delete(i.codes, r.PostForm.Get("code"))
+	i.mu.Unlock()
+	if !ok || time.Now().After(code.expiresAt) ||
+		r.PostForm.Get("client_id") != code.clientId ||
+		r.PostForm.Get("redirect_uri") != code.redirectURI ||
+		pkceChallenge(r.PostForm.Get("code_verifier")) != code.challenge {
+		writeOAuthError(w, http.StatusBadRequest, "invalid_grant")
+		return
+	}
+	now := time.Now()
+	idToken, err := i.keys.Sign(jwt.MapClaims{
+		"iss":                issuerURL(r),
+		"sub":                code.subject,
+		"aud":                code.clientId,
+		"iat":                now.Unix(),
+		"exp":                now.Add(time.Hour).Unix(),
+		"nonce":              code.nonce,
+		"email":              code.subject + "@example.test",
+		"preferred_username": code.subject,
+	})
+	if err != nil {
+		writeOAuthError(w, http.StatusInternalServerError, "server_error")
+		return
+	}
+	accessToken, _ := randomString(16)
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"access_token": accessToken,
+		"token_type":   "Bearer",
+		"expires_in":   3600,
+		"id_token":     idToken,
+	})
+}
+
+// writeOAuthError uses the error body from RFC 6749 section 5.2.
+func writeOAuthError(w http.ResponseWriter, status int, code string) {
+	WriteJSON(w, status, map[string]string{"error": code})
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/golang-jwt/jwt"
+)
+
+type oidcTest struct {
+	store  Storage
+	api    *httptest.Server
+	client *http.Client
+}
+
+// newOIDCTest serves the API with a "fake" provider backed by an in-process
+// FakeOIDCIssuer. The client keeps cookies but does not follow redirects,
+// so each step of the flow can be inspected.
+func newOIDCTest(t *testing.T) *oidcTest {
+	t.Helper()
+	issuer, err := NewFakeOIDCIssuer("test-client")
+	if err != nil {
+		t.Fatal(err)
+	}
+	issuerSrv := httptest.NewServer(issuer)
+	t.Cleanup(issuerSrv.Close)
+
+	keys := NewKeyRing()
+	keys.Add(&SigningKey{Id: legacyKeyId, Method: jwt.SigningMethodHS256, PrivateKey: []byte("secret"), PublicKey: []byte("secret")})
+	providers := map[string]*OIDCProvider{}
+	store := NewMemoryStore()
+	s := NewAPIServer("", ServerConfig{}, store, keys, CookiePolicy{Path: "/", SameSite: http.SameSiteLaxMode}, providers, LegacyPolicy{})
+	api := httptest.NewServer(RequestLogger(s.Router(), nil))
+	t.Cleanup(api.Close)
+	providers["fake"] = NewOIDCProvider("fake", issuerSrv.URL, "test-client", "", api.URL+"/v1/auth/fake/callback")
+
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &http.Client{
+		Jar: jar,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	return &oidcTest{store: store, api: api, client: client}
+}
+
+func (o *oidcTest) get(t *testing.T, u string) *http.Response {
+	t.Helper()
+	resp, err := o.client.Get(u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	return resp
+}
+
+// authorize starts a login for subject and returns the callback URL the
+// issuer redirected back to.
+func (o *oidcTest) authorize(t *testing.T, subject string) *url.URL {
+	t.Helper()
+	resp := o.get(t, o.api.URL+"/v1/auth/fake/login?login_hint="+subject)
+	if resp.StatusCode != http.StatusFound {
+		t.Fatalf("login status = %d, want 302", resp.StatusCode)
+	}
+	resp = o.get(t, resp.Header.Get("Location"))
+	if resp.StatusCode != http.StatusFound {
+		t.Fatalf("authorize status = %d, want 302", resp.StatusCode)
+	}
+	callback, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return callback
+}
+
+func TestOIDCLoginFlow(t *testing.T) {
+	o := newOIDCTest(t)
+	resp := o.get(t, o.authorize(t, "alice").String())
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("callback status = %d, want 200", resp.StatusCode)
+	}
+	var token bool
+	for _, c := range resp.Cookies() {
+		token = token || c.Name == "token" && c.Value != ""
+	}
+	if !token {
+		t.Fatal("callback did not set the token cookie")
+	}
+
+	ctx := context.Background()
+	acc, err := o.store.GetAccountByIdentity(ctx, "fake", "alice")
+	if err != nil {
+		t.Fatalf("identity not linked: %v", err)
+	}
+	if acc.Username != "alice" {
+		t.Errorf("username = %q, want alice", acc.Username)
+	}
+	sessions, err := o.store.GetAccountSessions(ctx, acc.Id, sessionList.All())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sessions) != 1 {
+		t.Fatalf("got %d sessions, want 1", len(sessions))
+	}
+
+	// A second login finds the linked account instead of creating one.
+	o.get(t, o.authorize(t, "alice").String())
+	again, err := o.store.GetAccountByIdentity(ctx, "fake", "alice")
+	if err != nil || again.Id != acc.Id {
+		t.Fatalf("second login account = %v, %v, want %d", again, err, acc.Id)
+	}
+}
+
+func TestOIDCCallbackRejectsTamperedState(t *testing.T) {
+	o := newOIDCTest(t)
+	callback := o.authorize(t, "alice")
+	q := callback.Query()
+	q.Set("state", q.Get("state")+"x")
+	callback.RawQuery = q.Encode()
+	if resp := o.get(t, callback.String()); resp.StatusCode != http.StatusUnprocessableEntity {
+		t.Fatalf("status = %d, want 422", resp.StatusCode)
+	}
+	if _, err := o.store.GetAccountByIdentity(context.Background(), "fake", "alice"); err == nil {
+		t.Fatal("identity linked despite a tampered state")
+	}
+}
+
+func TestOIDCCallbackRejectsTamperedNonce(t *testing.T) {
+	o := newOIDCTest(t)
+	callback := o.authorize(t, "alice")
+	apiURL, _ := url.Parse(o.api.URL)
+	for _, c := range o.client.Jar.Cookies(apiURL) {
+		if c.Name != oidcFlowCookieName {
+			continue
+		}
+		flow, err := decodeOIDCFlow(c.Value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		flow.Nonce += "x"
+		value, err := flow.encode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		o.client.Jar.SetCookies(apiURL, []*http.Cookie{{Name: oidcFlowCookieName, Value: value, Path: "/"}})
+	}
+	if resp := o.get(t, callback.String()); resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("status = %d, want 401", resp.StatusCode)
+	}
+	if _, err := o.store.GetAccountByIdentity(context.Background(), "fake", "alice"); err == nil {
+		t.Fatal("identity linked despite a tampered nonce")
+	}
+}
+
+func TestUsernameForIdentity(t *testing.T) {
+	a := newAPITest(t)
+	mustCreateAccount(t, a.store, "taken")
+	mustCreateAccount(t, a.store, "fake_taken")
+	long := strings.Repeat("x", 60)
+	tests := []struct {
+		name     string
+		identity OIDCIdentity
+		want     string
+	}{
+		{"Preferred", OIDCIdentity{Subject: "1", PreferredUsername: "alice"}, "alice"},
+		{"Email", OIDCIdentity{Subject: "1", Email: "bob.smith@example.test"}, "bob.smith"},
+		{"Spaces", OIDCIdentity{Subject: "1", PreferredUsername: "Jane Doe"}, "Jane_Doe"},
+		{"NonASCII", OIDCIdentity{Subject: "1", PreferredUsername: "zoë"}, "zo_"},
+		{"TooShort", OIDCIdentity{Subject: "1", PreferredUsername: "jo"}, "fake_1"},
+		{"Taken", OIDCIdentity{Subject: "2", PreferredUsername: "taken"}, "fake_2"},
+		{"SubjectCharacters", OIDCIdentity{Subject: "auth0|abc"}, "fake_auth0_abc"},
+		{"TooLong", OIDCIdentity{Subject: long}, ("fake_" + long)[:50]},
+		{"FallbackTaken", OIDCIdentity{Subject: "taken"}, "fake_taken_2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := a.s.usernameForIdentity(context.Background(), "fake", &tt.identity)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("username = %q, want %q", got, tt.want)
+			}
+			if err := Validate(&RegisterRequest{Username: got, Password: "password123"}); err != nil {
+				t.Errorf("/register would reject %q: %v", got, err)
+			}
+		})
+	}
+}
+
+func TestOIDCSignupNormalisesUsername(t *testing.T) {
+	o := newOIDCTest(t)
+	o.get(t, o.authorize(t, url.QueryEscape("jane doe")).String())
+	acc, err := o.store.GetAccountByIdentity(context.Background(), "fake", "jane doe")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if acc.Username != "jane_doe" {
+		t.Errorf("username = %q, want jane_doe", acc.Username)
+	}
+}
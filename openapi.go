@@ -0,0 +1,513 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"net/http"
+	"reflect"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	swaggerFiles "github.com/swaggo/files/v2"
+)
+
+// apiOperation documents one method of a route. Request and Response are
+// zero values of the body types; a nil Response means the operation has no
+// body, such as a redirect.
+type apiOperation struct {
+	Summary  string
+	Auth     bool
+	Request  any
+	Response any
+	Status   int
+	List     *ListSpec
+	Query    []string
+}
+
+// apiRoutes documents every route registered in Router, keyed by path
+// template without its /v1 prefix and by method. CheckOpenAPI compares it
+// with the methods each route registers.
+var apiRoutes = map[string]map[string]apiOperation{
+	"/login": {
+		"POST": {Summary: "Log in with a username and password", Request: LoginRequest{}, Response: WithStatusResponse{}},
+	},
+	"/register": {
+		"POST": {Summary: "Create an account", Request: RegisterRequest{}, Response: WithStatusResponse{}, Status: http.StatusCreated},
+	},
+	"/auth/{provider}/login": {
+		"GET": {Summary: "Start an OpenID Connect login", Status: http.StatusFound, Query: []string{"login_hint"}},
+	},
+	"/auth/{provider}/callback": {
+		"GET": {Summary: "Finish an OpenID Connect login", Response: WithStatusResponse{}, Query: []string{"code", "state"}},
+	},
+	"/accounts": {},
+	"/accounts/{id}": {
+		"GET":    {Summary: "Get an account you own, or any account as an admin", Auth: true, Response: Account{}},
+		"PATCH":  {Summary: "Update an account you own, or any account as an admin", Auth: true, Request: UpdateAccountRequest{}, Response: Account{}},
+		"DELETE": {Summary: "Delete an account and everything that belongs to it", Auth: true, Response: WithStatusResponse{}},
+	},
+	"/teams": {
+		"GET":  {Summary: "List favourite teams", Auth: true, Response: []Favourite{}, List: favouriteList},
+		"POST": {Summary: "Add a favourite team", Auth: true, Request: AddToFavourite{}, Response: WithStatusResponse{}, Status: http.StatusCreated},
+	},
+	"/teams/{abbr}": {
+		"DELETE": {Summary: "Remove a favourite team", Auth: true, Response: WithStatusResponse{}},
+	},
+	"/me": {
+		"GET":   {Summary: "Get the current account", Auth: true, Response: Account{}},
+		"PATCH": {Summary: "Update the current account", Auth: true, Request: UpdateAccountRequest{}, Response: Account{}},
+	},
+	"/me/favourites": {
+		"GET": {Summary: "List favourite teams", Auth: true, Response: []Favourite{}, List: favouriteList},
+		"PUT": {Summary: "Replace favourite teams, in order", Auth: true, Request: []Favourite{}, Response: []Favourite{}},
+	},
+	"/me/favourites/{abbr}": {
+		"DELETE": {Summary: "Remove a favourite team", Auth: true, Response: WithStatusResponse{}},
+	},
+	"/me/follows": {
+		"GET":  {Summary: "List followed teams, games and players", Auth: true, Response: []Follow{}, List: followList, Query: []string{"kind"}},
+		"POST": {Summary: "Follow a team, game or player", Auth: true, Request: Follow{}, Response: WithStatusResponse{}, Status: http.StatusCreated},
+	},
+	"/me/follows/{kind}/{ref}": {
+		"DELETE": {Summary: "Stop following a team, game or player", Auth: true, Response: WithStatusResponse{}},
+	},
+	"/me/export": {
+		"GET": {Summary: "Export all data stored about the current account", Auth: true, Response: AccountExport{}},
+	},
+	"/me/sessions": {
+		"GET": {Summary: "List active sessions", Auth: true, Response: []Session{}, List: sessionList},
+	},
+	"/me/sessions/{id}": {
+		"DELETE": {Summary: "Revoke a session", Auth: true, Response: WithStatusResponse{}},
+	},
+	"/admin/unlock": {
+		"POST": {Summary: "Clear login throttling for a username or IP", Auth: true, Request: UnlockRequest{}, Response: WithStatusResponse{}},
+	},
+	"/admin/cache": {
+		"GET": {Summary: "Hit and miss counters of the storage cache", Auth: true, Response: CacheStatsSnapshot{}},
+	},
+	"/.well-known/jwks.json": {
+		"GET": {Summary: "Public keys that verify issued tokens", Response: JWKS{}},
+	},
+	"/healthz": {
+		"GET": {Summary: "Liveness: the process is up", Response: HealthReport{}},
+	},
+	"/readyz": {
+		"GET": {Summary: "Readiness: database, migrations and workers are healthy; 503 otherwise", Response: HealthReport{}},
+	},
+	"/metrics": {
+		"GET": {Summary: "Prometheus metrics in the text exposition format", Status: http.StatusOK},
+	},
+	"/openapi.json": {
+		"GET": {Summary: "This OpenAPI document", Response: map[string]any{}},
+	},
+	"/docs/": {
+		"GET": {Summary: "Swagger UI for this OpenAPI document", Status: http.StatusOK},
+	},
+}
+
+type OpenAPI struct {
+	OpenAPI    string                           `json:"openapi"`
+	Info       OpenAPIInfo                      `json:"info"`
+	Paths      map[string]map[string]*OpenAPIOp `json:"paths"`
+	Components OpenAPIComponents                `json:"components"`
+}
+
+type OpenAPIInfo struct {
+	Title   string `json:"title"`
+	Version string `json:"version"`
+}
+
+type OpenAPIComponents struct {
+	Schemas         map[string]*OpenAPISchema    `json:"schemas"`
+	SecuritySchemes map[string]map[string]string `json:"securitySchemes"`
+}
+
+type OpenAPIOp struct {
+	Summary     string                  `json:"summary,omitempty"`
+	Parameters  []OpenAPIParameter      `json:"parameters,omitempty"`
+	RequestBody *OpenAPIBody            `json:"requestBody,omitempty"`
+	Responses   map[string]*OpenAPIBody `json:"responses"`
+	Security    []map[string][]string   `json:"security,omitempty"`
+	Deprecated  bool                    `json:"deprecated,omitempty"`
+}
+
+type OpenAPIParameter struct {
+	Name     string         `json:"name"`
+	In       string         `json:"in"`
+	Required bool           `json:"required,omitempty"`
+	Schema   *OpenAPISchema `json:"schema"`
+}
+
+type OpenAPIBody struct {
+	Description string                    `json:"description,omitempty"`
+	Required    bool                      `json:"required,omitempty"`
+	Content     map[string]map[string]any `json:"content,omitempty"`
+}
+
+type OpenAPISchema struct {
+	Ref        string                    `json:"$ref,omitempty"`
+	Type       string                    `json:"type,omitempty"`
+	Format     string                    `json:"format,omitempty"`
+	Properties map[string]*OpenAPISchema `json:"properties,omitempty"`
+	Required   []string                  `json:"required,omitempty"`
+	Items      *OpenAPISchema            `json:"items,omitempty"`
+	Enum       []string                  `json:"enum,omitempty"`
+	Pattern    string                    `json:"pattern,omitempty"`
+	MinLength  *int                      `json:"minLength,omitempty"`
+	MaxLength  *int                      `json:"maxLength,omitempty"`
+	Minimum    *int                      `json:"minimum,omitempty"`
+	Maximum    *int                      `json:"maximum,omitempty"`
+}
+
+// apiRoute is a route registered on the router. Key is its apiRoutes
+// entry, the template without the version prefix of its subrouter, and
+// Methods are the methods it matches, none for a route that was given no
+// method matcher.
+type apiRoute struct {
+	Template   string
+	Key        string
+	Methods    []string
+	Deprecated bool
+}
+
+// routeTemplates lists the routes registered on router, skipping the
+// subrouters themselves.
+func routeTemplates(router *mux.Router) []apiRoute {
+	var routes []apiRoute
+	router.Walk(func(route *mux.Route, _ *mux.Router, ancestors []*mux.Route) error {
+		t, err := route.GetPathTemplate()
+		if err != nil || route.GetHandler() == nil {
+			return nil
+		}
+		methods, _ := route.GetMethods()
+		r := apiRoute{Template: t, Key: t, Methods: methods}
+		if len(ancestors) > 0 {
+			if prefix, err := ancestors[0].GetPathTemplate(); err == nil {
+				r.Key = strings.TrimPrefix(t, prefix)
+			}
+			r.Deprecated = ancestors[0].GetName() == legacyRoutes
+		}
+		routes = append(routes, r)
+		return nil
+	})
+	return routes
+}
+
+// CheckOpenAPI reports methods registered on router without an apiRoutes
+// entry, and entries for methods no route accepts.
+func CheckOpenAPI(router *mux.Router) error {
+	var problems []string
+	registered := map[string]map[string]bool{}
+	for _, r := range routeTemplates(router) {
+		if registered[r.Key] == nil {
+			registered[r.Key] = map[string]bool{}
+		}
+		ops, ok := apiRoutes[r.Key]
+		if !ok {
+			problems = append(problems, fmt.Sprintf("route %s has no OpenAPI entry", r.Template))
+			continue
+		}
+		for _, method := range r.Methods {
+			// HEAD is documented by the GET it mirrors.
+			if method == "HEAD" && slices.Contains(r.Methods, "GET") {
+				continue
+			}
+			registered[r.Key][method] = true
+			if _, ok := ops[method]; !ok {
+				problems = append(problems, fmt.Sprintf("route %s %s has no OpenAPI entry", method, r.Template))
+			}
+		}
+	}
+	for t, ops := range apiRoutes {
+		if registered[t] == nil {
+			problems = append(problems, fmt.Sprintf("OpenAPI entry %s has no route", t))
+			continue
+		}
+		for method := range ops {
+			if !registered[t][method] {
+				problems = append(problems, fmt.Sprintf("OpenAPI entry %s %s has no route", method, t))
+			}
+		}
+	}
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return fmt.Errorf("%s", strings.Join(problems, "\n"))
+	}
+	return nil
+}
+
+// BuildOpenAPI generates the OpenAPI document for the routes on router.
+func BuildOpenAPI(router *mux.Router) *OpenAPI {
+	g := &schemaGenerator{schemas: map[string]*OpenAPISchema{}}
+	doc := &OpenAPI{
+		OpenAPI: "3.0.3",
+		Info:    OpenAPIInfo{Title: "go-nba", Version: "1.0.0"},
+		Paths:   map[string]map[string]*OpenAPIOp{},
+		Components: OpenAPIComponents{
+			Schemas: g.schemas,
+			SecuritySchemes: map[string]map[string]string{
+				"bearer": {"type": "http", "scheme": "bearer", "bearerFormat": "JWT"},
+				"cookie": {"type": "apiKey", "in": "cookie", "name": "token"},
+			},
+		},
+	}
+	problem := g.schema(reflect.TypeOf(ApiError{}), "")
+	for _, r := range routeTemplates(router) {
+		ops := apiRoutes[r.Key]
+		if len(ops) == 0 {
+			continue
+		}
+		item := map[string]*OpenAPIOp{}
+		for method, op := range ops {
+			out := g.operation(r.Template, op, problem)
+			out.Deprecated = r.Deprecated
+			item[strings.ToLower(method)] = out
+		}
+		doc.Paths[r.Template] = item
+	}
+	return doc
+}
+
+var pathParamPattern = regexp.MustCompile(`\{(\w+)\}`)
+
+func (g *schemaGenerator) operation(template string, op apiOperation, problem *OpenAPISchema) *OpenAPIOp {
+	out := &OpenAPIOp{Summary: op.Summary, Responses: map[string]*OpenAPIBody{}}
+	for _, m := range pathParamPattern.FindAllStringSubmatch(template, -1) {
+		schema := &OpenAPISchema{Type: "string"}
+		if m[1] == "id" {
+			schema = &OpenAPISchema{Type: "integer"}
+		}
+		out.Parameters = append(out.Parameters, OpenAPIParameter{Name: m[1], In: "path", Required: true, Schema: schema})
+	}
+	if op.List != nil {
+		out.Parameters = append(out.Parameters,
+			OpenAPIParameter{Name: "limit", In: "query", Schema: &OpenAPISchema{Type: "integer", Minimum: intPtr(1), Maximum: intPtr(maxPageLimit)}},
+			OpenAPIParameter{Name: "sort", In: "query", Schema: &OpenAPISchema{Type: "string", Pattern: sortPattern(op.List)}},
+			OpenAPIParameter{Name: "cursor", In: "query", Schema: &OpenAPISchema{Type: "string"}},
+			OpenAPIParameter{Name: "fields", In: "query", Schema: &OpenAPISchema{Type: "string"}},
+		)
+	}
+	for _, name := range op.Query {
+		out.Parameters = append(out.Parameters, OpenAPIParameter{Name: name, In: "query", Schema: &OpenAPISchema{Type: "string"}})
+	}
+	if op.Request != nil {
+		out.RequestBody = &OpenAPIBody{
+			Required: true,
+			Content:  map[string]map[string]any{"application/json": {"schema": g.schema(reflect.TypeOf(op.Request), "")}},
+		}
+	}
+	status := op.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	success := &OpenAPIBody{Description: http.StatusText(status)}
+	if op.Response != nil {
+		success.Content = map[string]map[string]any{"application/json": {"schema": g.schema(reflect.TypeOf(op.Response), "")}}
+	}
+	out.Responses[strconv.Itoa(status)] = success
+	out.Responses["default"] = &OpenAPIBody{
+		Description: "Problem details",
+		Content:     map[string]map[string]any{"application/problem+json": {"schema": problem}},
+	}
+	if op.Auth {
+		out.Security = []map[string][]string{{"bearer": {}}, {"cookie": {}}}
+	}
+	return out
+}
+
+func sortPattern(spec *ListSpec) string {
+	names := make([]string, len(spec.Fields))
+	for i, f := range spec.Fields {
+		names[i] = regexp.QuoteMeta(f.Name)
+	}
+	field := `-?(` + strings.Join(names, "|") + `)`
+	return `^` + field + `(,` + field + `)*$`
+}
+
+// schemaGenerator turns Go types into schemas, registering named structs
+// as components and referring to them by name.
+type schemaGenerator struct {
+	schemas map[string]*OpenAPISchema
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+func (g *schemaGenerator) schema(t reflect.Type, rules string) *OpenAPISchema {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch {
+	case t == timeType:
+		return &OpenAPISchema{Type: "string", Format: "date-time"}
+	case t.Kind() == reflect.Struct:
+		name := t.Name()
+		if _, ok := g.schemas[name]; !ok {
+			g.schemas[name] = nil
+			g.schemas[name] = g.object(t)
+		}
+		return &OpenAPISchema{Ref: "#/components/schemas/" + name}
+	case t.Kind() == reflect.Slice:
+		return &OpenAPISchema{Type: "array", Items: g.schema(t.Elem(), "")}
+	case t.Kind() == reflect.Map:
+		return &OpenAPISchema{Type: "object"}
+	case t.Kind() == reflect.Bool:
+		return &OpenAPISchema{Type: "boolean"}
+	case t.Kind() >= reflect.Int && t.Kind() <= reflect.Uint64:
+		s := &OpenAPISchema{Type: "integer"}
+		applyRules(s, rules)
+		return s
+	case t.Kind() == reflect.Float32 || t.Kind() == reflect.Float64:
+		return &OpenAPISchema{Type: "number"}
+	case t.Kind() == reflect.String:
+		s := &OpenAPISchema{Type: "string"}
+		applyRules(s, rules)
+		return s
+	}
+	return &OpenAPISchema{}
+}
+
+func (g *schemaGenerator) object(t reflect.Type) *OpenAPISchema {
+	s := &OpenAPISchema{Type: "object", Properties: map[string]*OpenAPISchema{}}
+	g.addFields(s, t)
+	return s
+}
+
+func (g *schemaGenerator) addFields(s *OpenAPISchema, t reflect.Type) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name, opts, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if f.Anonymous && name == "" {
+			embedded := f.Type
+			if embedded.Kind() == reflect.Pointer {
+				embedded = embedded.Elem()
+			}
+			g.addFields(s, embedded)
+			continue
+		}
+		if !f.IsExported() || name == "-" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		rules := f.Tag.Get("validate")
+		s.Properties[name] = g.schema(f.Type, rules)
+		if requiredRules(rules) && f.Type.Kind() != reflect.Pointer && !strings.Contains(opts, "omitempty") {
+			s.Required = append(s.Required, name)
+		}
+	}
+}
+
+// requiredRules reports whether rules reject an empty value.
+func requiredRules(rules string) bool {
+	for _, rule := range strings.Split(rules, ",") {
+		switch rule {
+		case "required", "username", "password", "abbr":
+			return true
+		}
+	}
+	return false
+}
+
+// applyRules mirrors the validate rules Validate enforces.
+func applyRules(s *OpenAPISchema, rules string) {
+	if rules == "" {
+		return
+	}
+	for _, rule := range strings.Split(rules, ",") {
+		name, arg, _ := strings.Cut(rule, "=")
+		switch name {
+		case "min", "max":
+			n, _ := strconv.Atoi(arg)
+			switch {
+			case s.Type == "integer" && name == "min":
+				s.Minimum = intPtr(n)
+			case s.Type == "integer":
+				s.Maximum = intPtr(n)
+			case name == "min":
+				s.MinLength = intPtr(n)
+			default:
+				s.MaxLength = intPtr(n)
+			}
+		case "oneof":
+			s.Enum = strings.Fields(arg)
+		case "username":
+			s.Pattern = usernamePattern.String()
+		case "password":
+			s.MinLength, s.MaxLength = intPtr(8), intPtr(72)
+		case "abbr":
+			s.Pattern = abbrPattern.String()
+		case "timezone":
+			s.Format = "iana-timezone"
+		case "ip":
+			s.Format = "ip"
+		}
+	}
+}
+
+func intPtr(n int) *int {
+	return &n
+}
+
+func (s *APIServer) handleOpenAPI(w http.ResponseWriter, r *http.Request) error {
+	return WriteJSON(w, http.StatusOK, s.openAPI)
+}
+
+// swaggerInitializer points the bundled Swagger UI at /openapi.json.
+const swaggerInitializer = `window.onload = function() {
+  window.ui = SwaggerUIBundle({
+    url: "/openapi.json",
+    dom_id: "#swagger-ui",
+    deepLinking: true,
+    presets: [SwaggerUIBundle.presets.apis, SwaggerUIStandalonePreset],
+    plugins: [SwaggerUIBundle.plugins.DownloadUrl],
+    layout: "StandaloneLayout"
+  });
+};
+`
+
+// swaggerUI serves the Swagger UI files bundled with swaggo/files. They
+// never change while the process runs, so their ETags are computed once and
+// http.ServeContent answers conditional and HEAD requests.
+func swaggerUI() http.Handler {
+	etags := map[string]string{}
+	err := fs.WalkDir(swaggerFiles.FS, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		data, err := fs.ReadFile(swaggerFiles.FS, name)
+		if err != nil {
+			return err
+		}
+		etags[name] = contentETag(data)
+		return nil
+	})
+	if err != nil {
+		panic(fmt.Sprintf("swagger ui: %v", err))
+	}
+	// The bundled initializer is replaced by ours.
+	etags["swagger-initializer.js"] = contentETag([]byte(swaggerInitializer))
+	files := http.FileServer(http.FS(swaggerFiles.FS))
+	return http.StripPrefix("/docs/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := r.URL.Path
+		if name == "" {
+			name = "index.html"
+		}
+		if etag, ok := etags[name]; ok {
+			w.Header().Set("ETag", etag)
+		}
+		w.Header().Set("Cache-Control", publicCache.header())
+		if name == "swagger-initializer.js" {
+			w.Header().Set("Content-Type", "text/javascript; charset=utf-8")
+			http.ServeContent(w, r, name, time.Time{}, strings.NewReader(swaggerInitializer))
+			return
+		}
+		files.ServeHTTP(w, r)
+	}))
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+// TestOpenAPICoversRoutes fails when a route method has no apiRoutes
+// entry, or an entry has no route.
+func TestOpenAPICoversRoutes(t *testing.T) {
+	if err := CheckOpenAPI((&APIServer{}).Router()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCheckOpenAPIComparesMethods(t *testing.T) {
+	noop := func(http.ResponseWriter, *http.Request) {}
+
+	router := (&APIServer{}).Router()
+	router.HandleFunc("/me/export", noop).Methods("DELETE")
+	err := CheckOpenAPI(router)
+	if err == nil || !strings.Contains(err.Error(), "route DELETE /me/export has no OpenAPI entry") {
+		t.Errorf("extra method: err = %v", err)
+	}
+
+	router = mux.NewRouter()
+	router.HandleFunc("/me", noop).Methods("GET")
+	err = CheckOpenAPI(router)
+	if err == nil || !strings.Contains(err.Error(), "OpenAPI entry PATCH /me has no route") {
+		t.Errorf("missing method: err = %v", err)
+	}
+	if strings.Contains(err.Error(), "GET /me ") {
+		t.Errorf("registered method reported: %v", err)
+	}
+}
@@ -0,0 +1,427 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultPageLimit = 20
+	maxPageLimit     = 100
+)
+
+type fieldKind int
+
+const (
+	stringField fieldKind = iota
+	intField
+	timeField
+)
+
+// ListField is a field a list endpoint can be sorted by. Name is used in
+// the sort parameter, Key is the JSON name of the field on the stored item
+// (defaulting to Name) and Column is the SQL expression it maps to.
+type ListField struct {
+	Name   string
+	Key    string
+	Column string
+	Kind   fieldKind
+}
+
+func (f ListField) key() string {
+	if f.Key != "" {
+		return f.Key
+	}
+	return f.Name
+}
+
+// ListSpec describes how a list endpoint can be sorted. Unique names the
+// fields appended to every sort so that rows are totally ordered, which
+// keyset cursors rely on.
+type ListSpec struct {
+	Fields  []ListField
+	Default string
+	Unique  []string
+}
+
+func (spec *ListSpec) field(name string) (ListField, bool) {
+	for _, f := range spec.Fields {
+		if f.Name == name {
+			return f, true
+		}
+	}
+	return ListField{}, false
+}
+
+// All requests every item in the default order.
+func (spec *ListSpec) All() *PageRequest {
+	page, err := spec.page(0, spec.Default, "", nil)
+	if err != nil {
+		panic(err)
+	}
+	return page
+}
+
+type sortKey struct {
+	field ListField
+	desc  bool
+}
+
+// PageRequest is a parsed page of a list: at most Limit items (0 means no
+// limit) ordered by Sort and starting after the cursor values in After.
+type PageRequest struct {
+	Limit  int
+	Sort   []sortKey
+	After  []any
+	Fields []string
+	sort   string
+}
+
+type pageCursor struct {
+	Sort   string `json:"s"`
+	Values []any  `json:"v"`
+}
+
+// ParsePage reads the limit, sort, cursor and fields query parameters of
+// a list request.
+func ParsePage(r *http.Request, spec *ListSpec) (*PageRequest, error) {
+	q := r.URL.Query()
+	limit := defaultPageLimit
+	if s := q.Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > maxPageLimit {
+			return nil, invalidFields(err, FieldError{Field: "limit", Message: fmt.Sprintf("must be between 1 and %d", maxPageLimit)})
+		}
+		limit = n
+	}
+	sortParam := q.Get("sort")
+	if sortParam == "" {
+		sortParam = spec.Default
+	}
+	var fields []string
+	if s := q.Get("fields"); s != "" {
+		fields = strings.Split(s, ",")
+	}
+	return spec.page(limit, sortParam, q.Get("cursor"), fields)
+}
+
+func (spec *ListSpec) page(limit int, sortParam string, cursor string, fields []string) (*PageRequest, error) {
+	page := &PageRequest{Limit: limit, Fields: fields, sort: sortParam}
+	seen := map[string]bool{}
+	for _, name := range strings.Split(sortParam, ",") {
+		desc := strings.HasPrefix(name, "-")
+		f, ok := spec.field(strings.TrimPrefix(name, "-"))
+		if !ok || seen[f.Name] {
+			return nil, invalidFields(nil, FieldError{Field: "sort", Message: "must list distinct fields out of " + spec.names()})
+		}
+		seen[f.Name] = true
+		page.Sort = append(page.Sort, sortKey{field: f, desc: desc})
+	}
+	for _, name := range spec.Unique {
+		if !seen[name] {
+			f, _ := spec.field(name)
+			page.Sort = append(page.Sort, sortKey{field: f})
+		}
+	}
+	if cursor != "" {
+		after, err := page.decodeCursor(cursor)
+		if err != nil {
+			return nil, invalidFields(err, FieldError{Field: "cursor", Message: "is not a cursor for this list and sort"})
+		}
+		page.After = after
+	}
+	return page, nil
+}
+
+func (spec *ListSpec) names() string {
+	names := make([]string, 0, len(spec.Fields))
+	for _, f := range spec.Fields {
+		names = append(names, f.Name)
+	}
+	return strings.Join(names, ", ")
+}
+
+func (p *PageRequest) decodeCursor(s string) ([]any, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	c := &pageCursor{}
+	if err := json.Unmarshal(raw, c); err != nil {
+		return nil, err
+	}
+	if c.Sort != p.sort || len(c.Values) != len(p.Sort) {
+		return nil, fmt.Errorf("cursor is for sort %q", c.Sort)
+	}
+	values := make([]any, len(c.Values))
+	for i, v := range c.Values {
+		values[i], err = decodeCursorValue(p.Sort[i].field.Kind, v)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return values, nil
+}
+
+func decodeCursorValue(kind fieldKind, v any) (any, error) {
+	switch kind {
+	case intField:
+		if n, ok := v.(float64); ok {
+			return int(n), nil
+		}
+	case timeField:
+		if s, ok := v.(string); ok {
+			return time.Parse(time.RFC3339Nano, s)
+		}
+	default:
+		if s, ok := v.(string); ok {
+			return s, nil
+		}
+	}
+	return nil, fmt.Errorf("unexpected cursor value %v", v)
+}
+
+// cursorAfter returns the cursor for the page following item.
+func (p *PageRequest) cursorAfter(item any) string {
+	raw, _ := json.Marshal(pageCursor{Sort: p.sort, Values: sortValues(item, p)})
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+// sqlClause appends the keyset condition, ordering and limit of the page to
+// query, which must already have a where clause. Placeholders are numbered
+// after args.
+func (p *PageRequest) sqlClause(query string, args []any) (string, []any) {
+	var b strings.Builder
+	b.WriteString(query)
+	if p.After != nil {
+		// (a > x) or (a = x and b > y) or ..., which unlike a row
+		// comparison allows each key its own direction.
+		var terms []string
+		for i, key := range p.Sort {
+			var conds []string
+			for j := 0; j < i; j++ {
+				args = append(args, p.After[j])
+				conds = append(conds, fmt.Sprintf("%s = $%d", p.Sort[j].field.Column, len(args)))
+			}
+			op := ">"
+			if key.desc {
+				op = "<"
+			}
+			args = append(args, p.After[i])
+			conds = append(conds, fmt.Sprintf("%s %s $%d", key.field.Column, op, len(args)))
+			terms = append(terms, "("+strings.Join(conds, " and ")+")")
+		}
+		b.WriteString(" and (" + strings.Join(terms, " or ") + ")")
+	}
+	order := make([]string, len(p.Sort))
+	for i, key := range p.Sort {
+		order[i] = key.field.Column
+		if key.desc {
+			order[i] += " desc"
+		}
+	}
+	b.WriteString(" order by " + strings.Join(order, ", "))
+	if p.Limit > 0 {
+		args = append(args, p.Limit+1)
+		fmt.Fprintf(&b, " limit $%d", len(args))
+	}
+	return b.String(), args
+}
+
+// paginate applies the page to items held in memory, mirroring sqlClause.
+func paginate[T any](items []T, p *PageRequest) []T {
+	sort.SliceStable(items, func(i, j int) bool {
+		return p.compare(sortValues(items[i], p), sortValues(items[j], p)) < 0
+	})
+	if p.After != nil {
+		i := sort.Search(len(items), func(i int) bool {
+			return p.compare(sortValues(items[i], p), p.After) > 0
+		})
+		items = items[i:]
+	}
+	if p.Limit > 0 && len(items) > p.Limit+1 {
+		items = items[:p.Limit+1]
+	}
+	return items
+}
+
+func sortValues(item any, p *PageRequest) []any {
+	values := make([]any, len(p.Sort))
+	for i, key := range p.Sort {
+		values[i] = sortValue(item, key.field.key())
+	}
+	return values
+}
+
+func (p *PageRequest) compare(a []any, b []any) int {
+	for i, key := range p.Sort {
+		c := compareValues(a[i], b[i])
+		if key.desc {
+			c = -c
+		}
+		if c != 0 {
+			return c
+		}
+	}
+	return 0
+}
+
+func compareValues(a any, b any) int {
+	switch a := a.(type) {
+	case int:
+		return a - b.(int)
+	case time.Time:
+		return a.Compare(b.(time.Time))
+	default:
+		return strings.Compare(a.(string), b.(string))
+	}
+}
+
+// sortValue reads the field with JSON name key from item, normalised to
+// an int, string or time.Time.
+func sortValue(item any, key string) any {
+	v, ok := jsonField(reflect.ValueOf(item), key)
+	if !ok {
+		panic(fmt.Sprintf("pagination: %T has no field %q", item, key))
+	}
+	switch v.Kind() {
+	case reflect.Int, reflect.Int64:
+		return int(v.Int())
+	case reflect.String:
+		return v.String()
+	}
+	if t, ok := v.Interface().(time.Time); ok {
+		return t.UTC()
+	}
+	panic(fmt.Sprintf("pagination: field %q of %T is not sortable", key, item))
+}
+
+func jsonField(v reflect.Value, name string) (reflect.Value, bool) {
+	for v.Kind() == reflect.Pointer {
+		v = v.Elem()
+	}
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.Anonymous {
+			if fv, ok := jsonField(v.Field(i), name); ok {
+				return fv, true
+			}
+			continue
+		}
+		if tag, _, _ := strings.Cut(f.Tag.Get("json"), ","); tag == name {
+			return v.Field(i), true
+		}
+	}
+	return reflect.Value{}, false
+}
+
+// nextPage trims the extra item a store returns past the page limit and
+// returns the cursor of the following page, or "" on the last page.
+func nextPage[T any](p *PageRequest, items []T) ([]T, string) {
+	if p.Limit == 0 || len(items) <= p.Limit {
+		return items, ""
+	}
+	items = items[:p.Limit]
+	return items, p.cursorAfter(items[len(items)-1])
+}
+
+// WritePage writes a page of items, keeping only the requested fields, and
+// links to the next page when there is one.
+func WritePage[T any](w http.ResponseWriter, r *http.Request, p *PageRequest, items []T, next string) error {
+	if next != "" {
+		q := r.URL.Query()
+		q.Set("cursor", next)
+		q.Set("limit", strconv.Itoa(p.Limit))
+		u := *r.URL
+		u.RawQuery = q.Encode()
+		w.Header().Add("Link", fmt.Sprintf(`<%s>; rel="next"`, u.String()))
+	}
+	if len(p.Fields) == 0 {
+		return WriteJSON(w, http.StatusOK, items)
+	}
+	known := jsonFieldNames(reflect.TypeOf(items).Elem())
+	for _, field := range p.Fields {
+		if !known[field] {
+			return invalidFields(nil, FieldError{Field: "fields", Message: fmt.Sprintf("%q is not a field of this list", field)})
+		}
+	}
+	selected := make([]map[string]json.RawMessage, 0, len(items))
+	for _, item := range items {
+		raw, err := json.Marshal(item)
+		if err != nil {
+			return err
+		}
+		all := map[string]json.RawMessage{}
+		if err := json.Unmarshal(raw, &all); err != nil {
+			return err
+		}
+		picked := map[string]json.RawMessage{}
+		for _, field := range p.Fields {
+			if v, ok := all[field]; ok {
+				picked[field] = v
+			}
+		}
+		selected = append(selected, picked)
+	}
+	return WriteJSON(w, http.StatusOK, selected)
+}
+
+func jsonFieldNames(t reflect.Type) map[string]bool {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	names := map[string]bool{}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		switch {
+		case f.Anonymous && name == "":
+			for n := range jsonFieldNames(f.Type) {
+				names[n] = true
+			}
+		case name != "-" && f.IsExported():
+			if name == "" {
+				name = f.Name
+			}
+			names[name] = true
+		}
+	}
+	return names
+}
+
+var (
+	sessionList = &ListSpec{
+		Fields: []ListField{
+			{Name: "id", Column: "id", Kind: intField},
+			{Name: "createdAt", Column: "created_at", Kind: timeField},
+			{Name: "lastSeenAt", Column: "last_seen_at", Kind: timeField},
+		},
+		Default: "-lastSeenAt",
+		Unique:  []string{"id"},
+	}
+	followList = &ListSpec{
+		Fields: []ListField{
+			{Name: "kind", Column: "f.kind"},
+			{Name: "ref", Column: "f.ref"},
+			{Name: "position", Column: "f.position", Kind: intField},
+		},
+		Default: "kind,position",
+		Unique:  []string{"kind", "ref"},
+	}
+	favouriteList = &ListSpec{
+		Fields: []ListField{
+			{Name: "abbr", Key: "ref", Column: "f.ref"},
+			{Name: "position", Column: "f.position", Kind: intField},
+		},
+		Default: "position",
+		Unique:  []string{"abbr"},
+	}
+)
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+var nextLink = regexp.MustCompile(`^<([^>]+)>; rel="next"$`)
+
+// pageLinks follows the next links from path and returns every page.
+func (a *apiTest) pageLinks(t *testing.T, path string, token string) [][]map[string]any {
+	t.Helper()
+	var pages [][]map[string]any
+	for path != "" {
+		if len(pages) > 100 {
+			t.Fatalf("%s: next links never reached the last page", path)
+		}
+		rec := a.do(t, "GET", path, token, nil)
+		wantStatus(t, rec, http.StatusOK)
+		var items []map[string]any
+		decodeBody(t, rec, &items)
+		pages = append(pages, items)
+		path = ""
+		if link := rec.Header().Get("Link"); link != "" {
+			m := nextLink.FindStringSubmatch(link)
+			if m == nil {
+				t.Fatalf("Link = %q, want a next link", link)
+			}
+			path = m[1]
+		}
+	}
+	return pages
+}
+
+// flatten joins pages after checking none is over limit.
+func flatten(t *testing.T, pages [][]map[string]any, limit int) []map[string]any {
+	t.Helper()
+	var all []map[string]any
+	for i, page := range pages {
+		if len(page) > limit || (len(page) == 0 && i > 0) {
+			t.Fatalf("page %d has %d items, limit %d", i, len(page), limit)
+		}
+		all = append(all, page...)
+	}
+	return all
+}
+
+func itemKeys(items []map[string]any, fields ...string) []string {
+	keys := []string{}
+	for _, item := range items {
+		var parts []string
+		for _, f := range fields {
+			parts = append(parts, fmt.Sprint(item[f]))
+		}
+		keys = append(keys, strings.Join(parts, ":"))
+	}
+	return keys
+}
+
+func TestPaginateFollowsOverHTTP(t *testing.T) {
+	for _, backend := range storageBackends {
+		t.Run(backend.name, func(t *testing.T) {
+			a := newAPITestWith(t, backend.open(t), ServerConfig{})
+			acc := mustCreateAccount(t, a.store, "alice")
+			token, _ := a.session(t, acc)
+			mustAddTeams(t, a.store, nbaTeams[:3]...)
+			for _, team := range nbaTeams[:3] {
+				if _, err := a.store.Follow(context.Background(), acc.Id, teamFollow(team.Abbr)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			for i := 0; i < 6; i++ {
+				f := &Follow{FollowTarget: FollowTarget{Kind: FollowPlayer, Ref: strconv.Itoa(100 + i)}}
+				if _, err := a.store.Follow(context.Background(), acc.Id, f); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			// Sorting by kind alone ties within a kind, so only the ref
+			// appended from Unique keeps the pages apart.
+			for _, sort := range []string{"", "kind", "-kind", "position", "-position,kind", "-ref"} {
+				q := url.Values{"sort": {sort}}
+				full := a.pageLinks(t, "/v1/me/follows?limit=100&"+q.Encode(), token)
+				if len(full) != 1 || len(full[0]) != 9 {
+					t.Fatalf("sort %q: %d pages, want one page of 9 follows", sort, len(full))
+				}
+				want := itemKeys(full[0], "kind", "ref")
+				got := itemKeys(flatten(t, a.pageLinks(t, "/v1/me/follows?limit=2&"+q.Encode(), token), 2), "kind", "ref")
+				if !reflect.DeepEqual(got, want) {
+					t.Errorf("sort %q: paged %v, want %v", sort, got, want)
+				}
+			}
+
+			// The kind filter is kept on the next link.
+			got := itemKeys(flatten(t, a.pageLinks(t, "/v1/me/follows?kind=team&limit=1", token), 1), "ref")
+			if want := []string{nbaTeams[0].Abbr, nbaTeams[1].Abbr, nbaTeams[2].Abbr}; !reflect.DeepEqual(got, want) {
+				t.Errorf("team follows = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestPaginateSessionsOverHTTP(t *testing.T) {
+	for _, backend := range storageBackends {
+		t.Run(backend.name, func(t *testing.T) {
+			a := newAPITestWith(t, backend.open(t), ServerConfig{})
+			acc := mustCreateAccount(t, a.store, "alice")
+			token, _ := a.session(t, acc)
+			// Pairs of sessions were last seen at the same moment.
+			seen := time.Now().Truncate(time.Second)
+			for i := 0; i < 6; i++ {
+				_, session := a.session(t, acc)
+				if err := a.store.TouchSession(context.Background(), session.Id, seen.Add(time.Duration(i/2)*time.Minute)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			for _, sort := range []string{"", "lastSeenAt", "-createdAt", "-id"} {
+				q := url.Values{"sort": {sort}}
+				full := a.pageLinks(t, "/v1/me/sessions?limit=100&"+q.Encode(), token)
+				want := itemKeys(full[0], "id")
+				if len(want) != 7 {
+					t.Fatalf("sort %q: %d sessions, want 7", sort, len(want))
+				}
+				got := itemKeys(flatten(t, a.pageLinks(t, "/v1/me/sessions?limit=2&"+q.Encode(), token), 2), "id")
+				if !reflect.DeepEqual(got, want) {
+					t.Errorf("sort %q: paged %v, want %v", sort, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestPageParameters(t *testing.T) {
+	a := newAPITest(t)
+	acc := mustCreateAccount(t, a.store, "alice")
+	token, _ := a.session(t, acc)
+	for i := 0; i < 3; i++ {
+		f := &Follow{FollowTarget: FollowTarget{Kind: FollowPlayer, Ref: strconv.Itoa(100 + i)}}
+		if _, err := a.store.Follow(context.Background(), acc.Id, f); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rec := a.do(t, "GET", "/v1/me/follows?sort=-ref&fields=ref,position&limit=2", token, nil)
+	wantStatus(t, rec, http.StatusOK)
+	m := nextLink.FindStringSubmatch(rec.Header().Get("Link"))
+	if m == nil {
+		t.Fatalf("Link = %q, want a next link", rec.Header().Get("Link"))
+	}
+	next, err := url.Parse(m[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := next.Query()
+	if next.Path != "/v1/me/follows" || q.Get("sort") != "-ref" || q.Get("fields") != "ref,position" || q.Get("limit") != "2" || q.Get("cursor") == "" {
+		t.Errorf("next link = %s, want the same path, sort, fields and limit with a cursor", m[1])
+	}
+	var items []map[string]json.RawMessage
+	decodeBody(t, rec, &items)
+	for _, item := range items {
+		if len(item) != 2 || item["ref"] == nil || item["position"] == nil {
+			t.Errorf("item = %s, want only ref and position", item)
+		}
+	}
+	if got := string(items[0]["ref"]); got != `"102"` {
+		t.Errorf("first ref = %s, want \"102\"", got)
+	}
+
+	rec = a.do(t, "GET", m[1], token, nil)
+	wantStatus(t, rec, http.StatusOK)
+	if link := rec.Header().Get("Link"); link != "" {
+		t.Errorf("last page Link = %q, want none", link)
+	}
+
+	cursor := q.Get("cursor")
+	for _, tc := range []struct {
+		name  string
+		query string
+		field string
+	}{
+		{"unknown sort field", "sort=team", "sort"},
+		{"repeated sort field", "sort=kind,-kind", "sort"},
+		{"zero limit", "limit=0", "limit"},
+		{"limit over the maximum", "limit=101", "limit"},
+		{"limit not a number", "limit=ten", "limit"},
+		{"unknown field", "fields=ref,password", "fields"},
+		{"cursor not base64", "sort=-ref&cursor=%21%21", "cursor"},
+		{"cursor for another sort", "sort=ref&cursor=" + cursor, "cursor"},
+		{"tampered cursor", "sort=-ref&cursor=" + cursor[:len(cursor)-4], "cursor"},
+		{"cursor with wrong value types", "sort=-ref&cursor=" + pageCursorFor(t, "-ref", 1, 2), "cursor"},
+		{"cursor with too few values", "sort=-ref&cursor=" + pageCursorFor(t, "-ref", "102"), "cursor"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := a.do(t, "GET", "/v1/me/follows?"+tc.query, token, nil)
+			wantStatus(t, rec, http.StatusUnprocessableEntity)
+			var problem ApiError
+			decodeBody(t, rec, &problem)
+			if len(problem.Errors) != 1 || problem.Errors[0].Field != tc.field {
+				t.Errorf("errors = %+v, want one for %s", problem.Errors, tc.field)
+			}
+		})
+	}
+}
+
+// pageCursorFor encodes a cursor by hand, as a client tampering with one
+// would.
+func pageCursorFor(t *testing.T, sort string, values ...any) string {
+	t.Helper()
+	raw, err := json.Marshal(pageCursor{Sort: sort, Values: values})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
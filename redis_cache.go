@@ -0,0 +1,245 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// redisTimeout bounds each command, including waiting for the connection
+// and dialling it. A slow or hung server then costs a cache miss instead of
+// stalling every request.
+const redisTimeout = 250 * time.Millisecond
+
+// redisMaxConns bounds the connections a RedisCache keeps open.
+const redisMaxConns = 8
+
+// RedisCache is a Cache backed by any server speaking the Redis protocol.
+// It needs only GET, SET with PX and DEL. Commands share a pool of up to
+// redisMaxConns connections; one that fails is dropped and a new one is
+// dialled when next needed.
+type RedisCache struct {
+	addr     string
+	password string
+	db       int
+	timeout  time.Duration
+	// slots bounds the connections in use. It is a channel rather than a
+	// semaphore so waiting for a slot gives up with the command's deadline.
+	slots  chan struct{}
+	idle   chan *redisConn
+	closed atomic.Bool
+}
+
+type redisConn struct {
+	net.Conn
+	rd *bufio.Reader
+}
+
+// NewRedisCache parses redis://[:password@]host:port[/db]. It does not
+// connect until the first command.
+func NewRedisCache(rawURL string) (*RedisCache, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	c := &RedisCache{
+		addr:    u.Host,
+		timeout: redisTimeout,
+		slots:   make(chan struct{}, redisMaxConns),
+		idle:    make(chan *redisConn, redisMaxConns),
+	}
+	if _, _, err := net.SplitHostPort(c.addr); err != nil {
+		c.addr = net.JoinHostPort(u.Host, "6379")
+	}
+	if u.User != nil {
+		c.password, _ = u.User.Password()
+	}
+	if db := strings.TrimPrefix(u.Path, "/"); db != "" {
+		if c.db, err = strconv.Atoi(db); err != nil {
+			return nil, fmt.Errorf("redis database %q: %w", db, err)
+		}
+	}
+	return c, nil
+}
+
+func (c *RedisCache) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	reply, err := c.do(ctx, "GET", key)
+	if err != nil || reply == nil {
+		return nil, false, err
+	}
+	value, ok := reply.([]byte)
+	if !ok {
+		return nil, false, fmt.Errorf("redis: unexpected GET reply %v", reply)
+	}
+	return value, true, nil
+}
+
+func (c *RedisCache) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	args := []string{"SET", key, string(value)}
+	if ttl > 0 {
+		args = append(args, "PX", strconv.FormatInt(ttl.Milliseconds(), 10))
+	}
+	_, err := c.do(ctx, args...)
+	return err
+}
+
+func (c *RedisCache) Delete(ctx context.Context, keys ...string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	_, err := c.do(ctx, append([]string{"DEL"}, keys...)...)
+	return err
+}
+
+// Close closes the idle connections. Connections still running a command
+// are closed when it finishes, and later commands fail.
+func (c *RedisCache) Close() error {
+	c.closed.Store(true)
+	var err error
+	for {
+		select {
+		case conn := <-c.idle:
+			if cerr := conn.Close(); err == nil {
+				err = cerr
+			}
+		default:
+			return err
+		}
+	}
+}
+
+var errRedisClosed = errors.New("redis: cache is closed")
+
+// do sends one command and reads its reply within the timeout. Bulk
+// strings come back as []byte, a nil bulk string as nil.
+func (c *RedisCache) do(ctx context.Context, args ...string) (any, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	select {
+	case c.slots <- struct{}{}:
+		defer func() { <-c.slots }()
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	if c.closed.Load() {
+		return nil, errRedisClosed
+	}
+	var conn *redisConn
+	select {
+	case conn = <-c.idle:
+	default:
+		var err error
+		if conn, err = c.dial(ctx); err != nil {
+			return nil, err
+		}
+	}
+	reply, err := conn.roundTrip(ctx, args)
+	var redisErr redisError
+	if (err != nil && !errors.As(err, &redisErr)) || c.closed.Load() {
+		conn.Close()
+		return reply, err
+	}
+	c.idle <- conn
+	return reply, err
+}
+
+func (c *RedisCache) dial(ctx context.Context) (*redisConn, error) {
+	var d net.Dialer
+	nc, err := d.DialContext(ctx, "tcp", c.addr)
+	if err != nil {
+		return nil, err
+	}
+	conn := &redisConn{Conn: nc, rd: bufio.NewReader(nc)}
+	if c.password != "" {
+		if _, err := conn.roundTrip(ctx, []string{"AUTH", c.password}); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	if c.db != 0 {
+		if _, err := conn.roundTrip(ctx, []string{"SELECT", strconv.Itoa(c.db)}); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+func (conn *redisConn) roundTrip(ctx context.Context, args []string) (any, error) {
+	deadline, _ := ctx.Deadline()
+	conn.SetDeadline(deadline)
+	if _, err := conn.Write(encodeRESP(args)); err != nil {
+		return nil, err
+	}
+	return readRESP(conn.rd)
+}
+
+// redisError is an error reply from the server. The connection stays
+// usable after one.
+type redisError string
+
+func (e redisError) Error() string {
+	return "redis: " + string(e)
+}
+
+func encodeRESP(args []string) []byte {
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	return []byte(b.String())
+}
+
+// readRESP reads one reply: simple strings as string, errors as
+// redisError, integers as int64, bulk strings as []byte and arrays as
+// []any.
+func readRESP(rd *bufio.Reader) (any, error) {
+	line, err := rd.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || !strings.HasSuffix(line, "\r\n") {
+		return nil, fmt.Errorf("redis: malformed reply %q", line)
+	}
+	kind, body := line[0], line[1:len(line)-2]
+	switch kind {
+	case '+':
+		return body, nil
+	case '-':
+		return nil, redisError(body)
+	case ':':
+		return strconv.ParseInt(body, 10, 64)
+	case '$':
+		n, err := strconv.Atoi(body)
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(rd, buf); err != nil {
+			return nil, err
+		}
+		return buf[:n], nil
+	case '*':
+		n, err := strconv.Atoi(body)
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		items := make([]any, n)
+		for i := range items {
+			if items[i], err = readRESP(rd); err != nil {
+				return nil, err
+			}
+		}
+		return items, nil
+	}
+	return nil, fmt.Errorf("redis: unknown reply type %q", kind)
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// startHungRedis accepts connections but never replies, like a Redis
+// server that has stopped responding.
+func startHungRedis(t *testing.T) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var mu sync.Mutex
+	var conns []net.Conn
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			mu.Lock()
+			conns = append(conns, conn)
+			mu.Unlock()
+		}
+	}()
+	t.Cleanup(func() {
+		l.Close()
+		mu.Lock()
+		defer mu.Unlock()
+		for _, conn := range conns {
+			conn.Close()
+		}
+	})
+	return "redis://" + l.Addr().String()
+}
+
+func TestRedisCacheTimesOutOnHungServer(t *testing.T) {
+	c, err := NewRedisCache(startHungRedis(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.timeout = 50 * time.Millisecond
+	defer c.Close()
+
+	// Concurrent callers each dial a connection of the pool; each still
+	// gives up within its own timeout.
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, ok, err := c.Get(context.Background(), "teams"); err == nil || ok {
+				t.Errorf("Get = %v, %v, want a timeout", ok, err)
+			}
+		}()
+	}
+	wg.Wait()
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("Gets took %s against a hung server", elapsed)
+	}
+}
+
+func TestCachedStoreFallsBackWhenRedisHangs(t *testing.T) {
+	c, err := NewRedisCache(startHungRedis(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.timeout = 50 * time.Millisecond
+	store := NewMemoryStore()
+	acc := mustCreateAccount(t, store, "alice")
+	mustAddTeams(t, store, nbaTeams[0])
+	if _, err := store.Follow(context.Background(), acc.Id, teamFollow(nbaTeams[0].Abbr)); err != nil {
+		t.Fatal(err)
+	}
+	cached := NewCachedStore(store, c, time.Minute)
+	defer cached.Close()
+
+	wantFollows(t, cached, acc.Id, nbaTeams[0].Abbr)
+	if stats := cached.Stats.Snapshot(); stats.Misses != 1 || stats.Errors == 0 {
+		t.Errorf("stats = %+v, want one miss and cache errors", stats)
+	}
+}
+
+// countingListener counts the connections it accepts.
+type countingListener struct {
+	net.Listener
+	accepted atomic.Int32
+}
+
+func (l *countingListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err == nil {
+		l.accepted.Add(1)
+	}
+	return conn, err
+}
+
+func TestRedisCachePoolsConnections(t *testing.T) {
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := &countingListener{Listener: inner}
+	defer l.Close()
+	go NewFakeRedis().Serve(l)
+	c, err := NewRedisCache("redis://" + l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	var wg sync.WaitGroup
+	for i := 0; i < 4*redisMaxConns; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			key := strconv.Itoa(i)
+			for j := 0; j < 10; j++ {
+				if err := c.Set(ctx, key, []byte(key), 0); err != nil {
+					t.Error(err)
+					return
+				}
+				if got, ok, err := c.Get(ctx, key); err != nil || !ok || string(got) != key {
+					t.Errorf("Get(%s) = %q, %v, %v", key, got, ok, err)
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	opened := l.accepted.Load()
+	if opened < 1 || opened > redisMaxConns {
+		t.Fatalf("opened %d connections, want 1 to %d", opened, redisMaxConns)
+	}
+	// Idle connections are reused rather than redialled.
+	for i := 0; i < 10; i++ {
+		wantCached(t, c, "1", "1")
+	}
+	if got := l.accepted.Load(); got != opened {
+		t.Errorf("opened %d connections after sequential reads, want %d", got, opened)
+	}
+
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := c.Get(ctx, "1"); !errors.Is(err, errRedisClosed) {
+		t.Errorf("Get after Close = %v, want %v", err, errRedisClosed)
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bufio"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// FakeRedis is a minimal in-process server for the Redis commands
+// RedisCache uses: PING, AUTH, SELECT, GET, SET with EX or PX, DEL and
+// FLUSHALL. It lets tests run the Redis backend without a real server.
+type FakeRedis struct {
+	mu    sync.Mutex
+	items map[string]fakeRedisItem
+}
+
+type fakeRedisItem struct {
+	value     string
+	expiresAt time.Time
+}
+
+func NewFakeRedis() *FakeRedis {
+	return &FakeRedis{items: map[string]fakeRedisItem{}}
+}
+
+// startFakeRedis serves a new FakeRedis until the test ends and returns its
+// redis:// URL.
+func startFakeRedis(t *testing.T) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go NewFakeRedis().Serve(l)
+	return "redis://" + l.Addr().String()
+}
+
+// Serve accepts connections on l until it is closed.
+func (r *FakeRedis) Serve(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go r.serveConn(conn)
+	}
+}
+
+func (r *FakeRedis) serveConn(conn net.Conn) {
+	defer conn.Close()
+	rd := bufio.NewReader(conn)
+	for {
+		req, err := readRESP(rd)
+		if err != nil {
+			return
+		}
+		items, ok := req.([]any)
+		if !ok || len(items) == 0 {
+			conn.Write([]byte("-ERR protocol error\r\n"))
+			return
+		}
+		args := make([]string, len(items))
+		for i, item := range items {
+			b, _ := item.([]byte)
+			args[i] = string(b)
+		}
+		conn.Write(r.exec(args))
+	}
+}
+
+func (r *FakeRedis) exec(args []string) []byte {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	switch strings.ToUpper(args[0]) {
+	case "PING":
+		return []byte("+PONG\r\n")
+	case "AUTH", "SELECT":
+		return []byte("+OK\r\n")
+	case "GET":
+		if len(args) != 2 {
+			return wrongArgs(args[0])
+		}
+		item, ok := r.items[args[1]]
+		if !ok || (!item.expiresAt.IsZero() && !time.Now().Before(item.expiresAt)) {
+			delete(r.items, args[1])
+			return []byte("$-1\r\n")
+		}
+		return []byte("$" + strconv.Itoa(len(item.value)) + "\r\n" + item.value + "\r\n")
+	case "SET":
+		if len(args) != 3 && len(args) != 5 {
+			return wrongArgs(args[0])
+		}
+		item := fakeRedisItem{value: args[2]}
+		if len(args) == 5 {
+			n, err := strconv.ParseInt(args[4], 10, 64)
+			if err != nil || n <= 0 {
+				return []byte("-ERR invalid expire time in 'set' command\r\n")
+			}
+			switch strings.ToUpper(args[3]) {
+			case "EX":
+				item.expiresAt = time.Now().Add(time.Duration(n) * time.Second)
+			case "PX":
+				item.expiresAt = time.Now().Add(time.Duration(n) * time.Millisecond)
+			default:
+				return []byte("-ERR syntax error\r\n")
+			}
+		}
+		r.items[args[1]] = item
+		return []byte("+OK\r\n")
+	case "DEL":
+		deleted := 0
+		for _, key := range args[1:] {
+			if _, ok := r.items[key]; ok {
+				delete(r.items, key)
+				deleted++
+			}
+		}
+		return []byte(":" + strconv.Itoa(deleted) + "\r\n")
+	case "FLUSHALL":
+		r.items = map[string]fakeRedisItem{}
+		return []byte("+OK\r\n")
+	}
+	return []byte("-ERR unknown command '" + args[0] + "'\r\n")
+}
+
+func wrongArgs(cmd string) []byte {
+	return []byte("-ERR wrong number of arguments for '" + strings.ToLower(cmd) + "' command\r\n")
+}
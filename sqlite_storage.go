@@ -0,0 +1,58 @@
+package main
+
+import (
+	"database/sql"
+	"regexp"
+	"strings"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// SQLiteStore runs the shared queries against an embedded SQLite database
+// so the API can be used without a Postgres server.
+type SQLiteStore struct {
+	sqlStore
+}
+
+// NewSQLiteStore opens the database file at path, or a private in-memory
+// database for ":memory:".
+func NewSQLiteStore(path string) (*SQLiteStore, error) {
+	dsn := "file:" + path + "?_foreign_keys=1&_busy_timeout=5000"
+	if path != ":memory:" {
+		dsn += "&_journal_mode=WAL"
+	}
+	db, err := sql.Open("sqlite3", dsn)
+	if err != nil {
+		return nil, err
+	}
+	if path == ":memory:" || strings.Contains(path, "mode=memory") {
+		// Every connection would otherwise get its own empty database.
+		db.SetMaxOpenConns(1)
+	}
+	if err := db.Ping(); err != nil {
+		return nil, err
+	}
+	return &SQLiteStore{sqlStore{db: db, rebind: sqliteRebind}}, nil
+}
+
+var sqlitePlaceholder = regexp.MustCompile(`\$(\d+)`)
+
+// sqliteRebind turns Postgres $N placeholders into ?N. SQLite treats $N as
+// a named parameter and numbers those by first appearance, not by N.
+func sqliteRebind(query string) string {
+	return sqlitePlaceholder.ReplaceAllString(query, "?$1")
+}
+
+// Init brings the schema up to date by applying pending migrations. SQLite
+// serialises writers itself, so no extra lock is taken.
+func (s *SQLiteStore) Init() error {
+	m, err := s.Migrator()
+	if err != nil {
+		return err
+	}
+	return m.Up()
+}
+
+func (s *SQLiteStore) Migrator() (*Migrator, error) {
+	return newMigrator(&s.sqlStore, "sqlite", nil)
+}
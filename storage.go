@@ -1,214 +1,690 @@
 package main
 
 import (
+	"context"
 	"database/sql"
-	"fmt"
+	"errors"
+	"time"
 
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 )
 
 type Storage interface {
-	CreateAccount(*Account) error
-	DeleteAccount(int) error
-	UpdateAccount(*Account) error
-	GetAccountById(int) (*Account, error)
-	GetAccountByUsername(string) (*Account, error)
-	AddTeam(*Team) error
-	AddTeamToFavourite(int, string) error
-	GetAccountFavouriteTeams(int) ([]*Team, error)
+	CreateAccount(context.Context, *Account) error
+	DeleteAccount(context.Context, int) error
+	UpdateAccount(context.Context, *Account) error
+	GetAccountById(context.Context, int) (*Account, error)
+	GetAccountByUsername(context.Context, string) (*Account, error)
+	AddTeam(context.Context, *Team) error
+	GetTeams(context.Context) ([]*Team, error)
+	Follow(context.Context, int, *Follow) (bool, error)
+	Unfollow(context.Context, int, FollowTarget) error
+	GetFollows(context.Context, int, FollowKind, *PageRequest) ([]*Follow, error)
+	ReplaceFollows(context.Context, int, FollowKind, []*Follow) error
+	GetLoginAttempt(context.Context, string) (*LoginAttempt, error)
+	RecordLoginFailure(context.Context, string, time.Time) (*LoginAttempt, error)
+	LockLogin(context.Context, string, time.Time) error
+	ResetLoginAttempts(context.Context, string) error
+	CreateSession(context.Context, *Session) error
+	GetSessionById(context.Context, int) (*Session, error)
+	GetAccountSessions(context.Context, int, *PageRequest) ([]*Session, error)
+	GetAllAccountSessions(context.Context, int) ([]*Session, error)
+	TouchSession(context.Context, int, time.Time) error
+	RevokeSession(context.Context, int, int) error
+	GetAccountByIdentity(context.Context, string, string) (*Account, error)
+	LinkIdentity(context.Context, int, string, string) error
+	GetAccountIdentities(context.Context, int) ([]*Identity, error)
+	Ping(context.Context) error
+	Close() error
 }
+
+var (
+	ErrAccountNotFound = NotFound("No account found.")
+	ErrSessionNotFound = NotFound("No session found.")
+	ErrFollowNotFound  = NotFound("No follow found.")
+	ErrStaleAccount    = Conflict("Account was modified by another request. Reload it and try again.")
+)
+
+// isCanceled reports whether err comes from a cancelled or timed out
+// context. Postgres reports a query cancelled on our behalf as 57014.
+func isCanceled(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "57014"
+}
+
+// sqlStore holds the queries shared by every database/sql backend. Each
+// backend embeds it and supplies its own schema.
+type sqlStore struct {
+	db      *sql.DB
+	rebind  func(string) string
+	timeout time.Duration
+}
+
+func (s *sqlStore) Ping(ctx context.Context) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	return s.db.PingContext(ctx)
+}
+
+// Close closes the database once in-flight queries have finished.
+func (s *sqlStore) Close() error {
+	return s.db.Close()
+}
+
+func (s *sqlStore) exec(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	res, err := s.db.ExecContext(ctx, s.bind(query), args...)
+	return res, translateDBError(err)
+}
+
+func (s *sqlStore) query(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	rows, err := s.db.QueryContext(ctx, s.bind(query), args...)
+	return rows, translateDBError(err)
+}
+
+func (s *sqlStore) queryRow(ctx context.Context, query string, args ...any) scanner {
+	return translatedRow{s.db.QueryRowContext(ctx, s.bind(query), args...)}
+}
+
+// inTx runs fn in a transaction, committing if it returns nil and
+// rolling back otherwise.
+func (s *sqlStore) inTx(ctx context.Context, fn func(tx *sqlTx) error) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return translateDBError(err)
+	}
+	if err := fn(&sqlTx{tx: tx, store: s}); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return translateDBError(tx.Commit())
+}
+
+// sqlTx mirrors the sqlStore query helpers inside a transaction.
+type sqlTx struct {
+	tx    *sql.Tx
+	store *sqlStore
+}
+
+func (t *sqlTx) exec(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	res, err := t.tx.ExecContext(ctx, t.store.bind(query), args...)
+	return res, translateDBError(err)
+}
+
+func (t *sqlTx) queryRow(ctx context.Context, query string, args ...any) scanner {
+	return translatedRow{t.tx.QueryRowContext(ctx, t.store.bind(query), args...)}
+}
+
+// translatedRow surfaces constraint violations from single-row statements,
+// which only report them on Scan.
+type translatedRow struct {
+	row *sql.Row
+}
+
+func (r translatedRow) Scan(dest ...any) error {
+	return translateDBError(r.row.Scan(dest...))
+}
+
+// withTimeout bounds a single storage operation. The caller must keep the
+// returned context alive until any rows have been scanned.
+func (s *sqlStore) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if s.timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, s.timeout)
+}
+
+func (s *sqlStore) bind(query string) string {
+	if s.rebind == nil {
+		return query
+	}
+	return s.rebind(query)
+}
+
 type PostgresStore struct {
-	db *sql.DB
+	sqlStore
 }
 
-func NewPostgresStore() (*PostgresStore, error) {
-	conSrt := "user=iufb dbname=go-nba port=5433 password=1243 sslmode=disable"
-	db, err := sql.Open("postgres", conSrt)
+func NewPostgresStore(dsn string) (*PostgresStore, error) {
+	db, err := sql.Open("postgres", dsn)
 	if err != nil {
 		return nil, err
 	}
 	if err := db.Ping(); err != nil {
 		return nil, err
 	}
-	return &PostgresStore{db: db}, nil
-}
-
-// var teams = [30]Team{
-// 	{Name: "Atlanta Hawks", Abbr: "ATL"},
-// 	{Name: "Boston Celtics", Abbr: "BOS"},
-// 	{Name: "Brooklyn Nets", Abbr: "BKN"},
-// 	{Name: "Charlotte Hornets", Abbr: "CHA"},
-// 	{Name: "Chicago Bulls", Abbr: "CHI"},
-// 	{Name: "Cleveland Cavaliers", Abbr: "CLE"},
-// 	{Name: "Dallas Mavericks", Abbr: "DAL"},
-// 	{Name: "Denver Nuggets", Abbr: "DEN"},
-// 	{Name: "Detroit Pistons", Abbr: "DET"},
-// 	{Name: "Golden State Warriors", Abbr: "GSW"},
-// 	{Name: "Houston Rockets", Abbr: "HOU"},
-// 	{Name: "Indiana Pacers", Abbr: "IND"},
-// 	{Name: "LA Clippers", Abbr: "LAC"},
-// 	{Name: "Los Angeles Lakers", Abbr: "LAL"},
-// 	{Name: "Memphis Grizzlies", Abbr: "MEM"},
-// 	{Name: "Miami Heat", Abbr: "MIA"},
-// 	{Name: "Milwaukee Bucks", Abbr: "MIL"},
-// 	{Name: "Minnesota Timberwolves", Abbr: "MIN"},
-// 	{Name: "New Orleans Pelicans", Abbr: "NOP"},
-// 	{Name: "New York Knicks", Abbr: "NYK"},
-// 	{Name: "Oklahoma City Thunder", Abbr: "OKC"},
-// 	{Name: "Orlando Magic", Abbr: "ORL"},
-// 	{Name: "Philadelphia 76ers", Abbr: "PHI"},
-// 	{Name: "Phoenix Suns", Abbr: "PHX"},
-// 	{Name: "Portland Trail Blazers", Abbr: "POR"},
-// 	{Name: "Sacramento Kings", Abbr: "SAC"},
-// 	{Name: "San Antonio Spurs", Abbr: "SAS"},
-// 	{Name: "Toronto Raptors", Abbr: "TOR"},
-// 	{Name: "Utah Jazz", Abbr: "UTA"},
-// 	{Name: "Washington Wizards", Abbr: "WAS"},
-// }
+	return &PostgresStore{sqlStore{db: db}}, nil
+}
+
+// nbaTeams is the league roster used to seed stores for demos.
+var nbaTeams = [30]Team{
+	{Name: "Atlanta Hawks", Abbr: "ATL"},
+	{Name: "Boston Celtics", Abbr: "BOS"},
+	{Name: "Brooklyn Nets", Abbr: "BKN"},
+	{Name: "Charlotte Hornets", Abbr: "CHA"},
+	{Name: "Chicago Bulls", Abbr: "CHI"},
+	{Name: "Cleveland Cavaliers", Abbr: "CLE"},
+	{Name: "Dallas Mavericks", Abbr: "DAL"},
+	{Name: "Denver Nuggets", Abbr: "DEN"},
+	{Name: "Detroit Pistons", Abbr: "DET"},
+	{Name: "Golden State Warriors", Abbr: "GSW"},
+	{Name: "Houston Rockets", Abbr: "HOU"},
+	{Name: "Indiana Pacers", Abbr: "IND"},
+	{Name: "LA Clippers", Abbr: "LAC"},
+	{Name: "Los Angeles Lakers", Abbr: "LAL"},
+	{Name: "Memphis Grizzlies", Abbr: "MEM"},
+	{Name: "Miami Heat", Abbr: "MIA"},
+	{Name: "Milwaukee Bucks", Abbr: "MIL"},
+	{Name: "Minnesota Timberwolves", Abbr: "MIN"},
+	{Name: "New Orleans Pelicans", Abbr: "NOP"},
+	{Name: "New York Knicks", Abbr: "NYK"},
+	{Name: "Oklahoma City Thunder", Abbr: "OKC"},
+	{Name: "Orlando Magic", Abbr: "ORL"},
+	{Name: "Philadelphia 76ers", Abbr: "PHI"},
+	{Name: "Phoenix Suns", Abbr: "PHX"},
+	{Name: "Portland Trail Blazers", Abbr: "POR"},
+	{Name: "Sacramento Kings", Abbr: "SAC"},
+	{Name: "San Antonio Spurs", Abbr: "SAS"},
+	{Name: "Toronto Raptors", Abbr: "TOR"},
+	{Name: "Utah Jazz", Abbr: "UTA"},
+	{Name: "Washington Wizards", Abbr: "WAS"},
+}
 
+// Init brings the schema up to date by applying pending migrations.
 func (s *PostgresStore) Init() error {
-	err := s.CreateAccountTable()
+	m, err := s.Migrator()
 	if err != nil {
 		return err
 	}
-	err = s.CreateTeamTable()
+	return m.Up()
+}
+
+func (s *PostgresStore) Migrator() (*Migrator, error) {
+	return newMigrator(&s.sqlStore, "postgres", postgresAdvisoryLock)
+}
+
+func (s *sqlStore) AddTeam(ctx context.Context, team *Team) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	query := `
+ INSERT INTO teams (name,abbr)
+VALUES ($1,$2);
+      `
+	_, err := s.exec(ctx, query, team.Name, team.Abbr)
 	if err != nil {
 		return err
 	}
-	err = s.CreateAccountTeamsTable()
+	return nil
+}
+
+func (s *sqlStore) GetTeams(ctx context.Context) ([]*Team, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	query := `
+    select name, abbr from teams order by id
+    `
+	rows, err := s.query(ctx, query)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	return nil
+	defer rows.Close()
+	teams := []*Team{}
+	for rows.Next() {
+		team := &Team{}
+		if err := rows.Scan(&team.Name, &team.Abbr); err != nil {
+			return nil, err
+		}
+		teams = append(teams, team)
+	}
+	return teams, rows.Err()
 }
 
-func (s *PostgresStore) CreateAccountTable() error {
-	query := `create table if not exists accounts  (
-       id SERIAL PRIMARY KEY,
-       username varchar(50) UNIQUE,
-       encrypted_password varchar(100),
-       created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
- )`
-	_, err := s.db.Exec(query)
+func (s *sqlStore) CreateAccount(ctx context.Context, acc *Account) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	query := `
+INSERT INTO accounts ( username,encrypted_password,timezone,time_format)
+VALUES ($1,$2,$3,$4)
+RETURNING id, created_at, version;
+    `
+	if acc.Timezone == "" {
+		acc.Timezone = "UTC"
+	}
+	if acc.TimeFormat == "" {
+		acc.TimeFormat = "24h"
+	}
+	err := s.queryRow(ctx, query, acc.Username, acc.EncryptedPassword, acc.Timezone, acc.TimeFormat).Scan(&acc.Id, &acc.CreatedAt, &acc.Version)
+	acc.UpdatedAt = acc.CreatedAt
 	return err
 }
 
-func (s *PostgresStore) CreateTeamTable() error {
-	query := ` create table if not exists teams (
-       id SERIAL PRIMARY KEY,
-       name varchar(50),
-       abbr varchar(3) UNIQUE
-    )
+func (s *sqlStore) GetAccountById(ctx context.Context, id int) (*Account, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	query := `
+    select ` + accountColumns + ` from accounts a where id = $1
     `
-	_, err := s.db.Exec(query)
+	row := s.queryRow(ctx, query, id)
+	return scanIntoAccount(row)
+}
+
+func (s *sqlStore) GetAccountByUsername(ctx context.Context, username string) (*Account, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	query := `
+    select ` + accountColumns + ` from accounts a where username = $1
+    `
+	row := s.queryRow(ctx, query, username)
+	return scanIntoAccount(row)
+}
+
+func (s *sqlStore) GetAccountByIdentity(ctx context.Context, provider string, subject string) (*Account, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	query := `
+    select ` + accountColumns + ` from accounts a
+    join account_identities i on i.account_id = a.id where i.provider = $1 and i.subject = $2
+    `
+	row := s.queryRow(ctx, query, provider, subject)
+	return scanIntoAccount(row)
+}
+
+func (s *sqlStore) GetAccountIdentities(ctx context.Context, accountId int) ([]*Identity, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	query := `
+    select provider, subject, created_at from account_identities where account_id = $1 order by created_at
+    `
+	rows, err := s.query(ctx, query, accountId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	identities := []*Identity{}
+	for rows.Next() {
+		identity := &Identity{AccountId: accountId}
+		if err := rows.Scan(&identity.Provider, &identity.Subject, &identity.CreatedAt); err != nil {
+			return nil, err
+		}
+		identities = append(identities, identity)
+	}
+	return identities, rows.Err()
+}
+
+func (s *sqlStore) LinkIdentity(ctx context.Context, accountId int, provider string, subject string) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	query := `
+INSERT INTO account_identities (provider, subject, account_id)
+VALUES ($1,$2,$3);
+    `
+	_, err := s.exec(ctx, query, provider, subject, accountId)
 	return err
 }
 
-func (s *PostgresStore) CreateAccountTeamsTable() error {
-	query := ` create table if not exists account_teams(
-       account_id INT,
-       team_abbr varchar(3),
-       PRIMARY KEY(account_id, team_abbr),
-       FOREIGN KEY(account_id) REFERENCES accounts(id),
-       FOREIGN KEY(team_abbr) REFERENCES teams(abbr)
-    )
+// UpdateAccount saves acc if its Version still matches the stored row and
+// bumps the version, returning ErrStaleAccount when another write won.
+func (s *sqlStore) UpdateAccount(ctx context.Context, acc *Account) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	query := `
+    update accounts set username = $2, encrypted_password = $3, timezone = $4, time_format = $5,
+        updated_at = $6, version = version + 1
+    where id = $1 and version = $7
+    returning version, updated_at
     `
-	_, err := s.db.Exec(query)
+	err := s.queryRow(ctx, query, acc.Id, acc.Username, acc.EncryptedPassword, acc.Timezone, acc.TimeFormat, time.Now().UTC(), acc.Version).
+		Scan(&acc.Version, &acc.UpdatedAt)
+	if err != sql.ErrNoRows {
+		return err
+	}
+	if _, err := s.GetAccountById(ctx, acc.Id); err != nil {
+		return err
+	}
+	return ErrStaleAccount
+}
+
+// DeleteAccount removes the account with its favourites, sessions, linked
+// identities and login throttling state in a single transaction.
+func (s *sqlStore) DeleteAccount(ctx context.Context, id int) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	return s.inTx(ctx, func(tx *sqlTx) error {
+		var username string
+		err := tx.queryRow(ctx, `select username from accounts where id = $1`, id).Scan(&username)
+		if err == sql.ErrNoRows {
+			return ErrAccountNotFound
+		}
+		if err != nil {
+			return err
+		}
+		for _, query := range []string{
+			`delete from follows where account_id = $1`,
+			`delete from account_identities where account_id = $1`,
+			`delete from sessions where account_id = $1`,
+			`delete from accounts where id = $1`,
+		} {
+			if _, err := tx.exec(ctx, query, id); err != nil {
+				return err
+			}
+		}
+		_, err = tx.exec(ctx, `delete from login_attempts where key = $1`, usernameLoginKey(username).key)
+		return err
+	})
+}
+
+// checkFollowTarget rejects team follows of teams that do not exist. Game
+// and player ids are not checked.
+func (s *sqlStore) checkFollowTarget(ctx context.Context, target FollowTarget) error {
+	if target.Kind != FollowTeam {
+		return nil
+	}
+	var found int
+	err := s.queryRow(ctx, `select 1 from teams where abbr = $1`, target.Ref).Scan(&found)
+	if err == sql.ErrNoRows {
+		return NotFound("Unknown team %q.", target.Ref)
+	}
 	return err
 }
 
-func (s *PostgresStore) AddTeam(team *Team) error {
+// Follow appends follow to the end of the account's follows of its kind
+// and reports whether it was added; following twice is a no-op.
+func (s *sqlStore) Follow(ctx context.Context, accountId int, follow *Follow) (bool, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	if err := s.checkFollowTarget(ctx, follow.FollowTarget); err != nil {
+		return false, err
+	}
 	query := `
- INSERT INTO teams (name,abbr)
-VALUES ($1,$2);
-      `
-	_, err := s.db.Exec(query, team.Name, team.Abbr)
+INSERT INTO follows(account_id,kind,ref,position,notify_tip_off,notify_final_score,notify_schedule_change)
+VALUES ($1,$2,$3,(select coalesce(max(position), 0) + 1 from follows where account_id = $1 and kind = $2),$4,$5,$6)
+ON CONFLICT (account_id, kind, ref) DO NOTHING;
+    `
+	res, err := s.exec(ctx, query, accountId, follow.Kind, follow.Ref,
+		follow.Notifications.TipOff, follow.Notifications.FinalScore, follow.Notifications.ScheduleChange)
 	if err != nil {
-		return err
+		return false, err
 	}
-	return nil
+	n, err := res.RowsAffected()
+	return n > 0, err
 }
 
-func (s *PostgresStore) CreateAccount(acc *Account) error {
+func (s *sqlStore) Unfollow(ctx context.Context, accountId int, target FollowTarget) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
 	query := `
-INSERT INTO accounts ( username,encrypted_password)
-VALUES ($1,$2);
+    delete from follows where account_id = $1 and kind = $2 and ref = $3
     `
-	_, err := s.db.Exec(query, acc.Username, acc.EncryptedPassword)
+	res, err := s.exec(ctx, query, accountId, target.Kind, target.Ref)
 	if err != nil {
 		return err
 	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrFollowNotFound
+	}
 	return nil
 }
 
-func (s *PostgresStore) GetAccountById(id int) (*Account, error) {
+// GetFollows lists a page of the account's follows of kind, or of every
+// kind when kind is empty.
+func (s *sqlStore) GetFollows(ctx context.Context, accountId int, kind FollowKind, page *PageRequest) ([]*Follow, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
 	query := `
-    select * from accounts where id = $1
+    select f.kind, f.ref, t.name, f.position, f.notify_tip_off, f.notify_final_score, f.notify_schedule_change, f.created_at
+    from follows f left join teams t on f.kind = 'team' and t.abbr = f.ref
+    where f.account_id = $1`
+	args := []any{accountId}
+	if kind != "" {
+		query += ` and f.kind = $2`
+		args = append(args, kind)
+	}
+	query, args = page.sqlClause(query, args)
+	rows, err := s.query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	follows := []*Follow{}
+	for rows.Next() {
+		follow := &Follow{}
+		var teamName sql.NullString
+		err := rows.Scan(&follow.Kind, &follow.Ref, &teamName, &follow.Position,
+			&follow.Notifications.TipOff, &follow.Notifications.FinalScore, &follow.Notifications.ScheduleChange,
+			&follow.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		if teamName.Valid {
+			follow.Team = &Team{Name: teamName.String, Abbr: follow.Ref}
+		}
+		follows = append(follows, follow)
+	}
+	return follows, rows.Err()
+}
+
+// ReplaceFollows swaps the account's follows of kind for follows, keeping
+// their order, in a single transaction.
+func (s *sqlStore) ReplaceFollows(ctx context.Context, accountId int, kind FollowKind, follows []*Follow) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	for _, follow := range follows {
+		if err := s.checkFollowTarget(ctx, FollowTarget{Kind: kind, Ref: follow.Ref}); err != nil {
+			return err
+		}
+	}
+	return s.inTx(ctx, func(tx *sqlTx) error {
+		if _, err := tx.exec(ctx, `delete from follows where account_id = $1 and kind = $2`, accountId, kind); err != nil {
+			return err
+		}
+		query := `
+INSERT INTO follows(account_id,kind,ref,position,notify_tip_off,notify_final_score,notify_schedule_change)
+VALUES ($1,$2,$3,$4,$5,$6,$7);
     `
-	row := s.db.QueryRow(query, id)
-	return scanIntoAccount(row)
+		for i, follow := range follows {
+			_, err := tx.exec(ctx, query, accountId, kind, follow.Ref, i+1,
+				follow.Notifications.TipOff, follow.Notifications.FinalScore, follow.Notifications.ScheduleChange)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
 }
 
-func (s *PostgresStore) GetAccountByUsername(username string) (*Account, error) {
+func (s *sqlStore) GetLoginAttempt(ctx context.Context, key string) (*LoginAttempt, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
 	query := `
-    select * from accounts where username = $1
+    select key, failures, last_failure, locked_until from login_attempts where key = $1
     `
-	row := s.db.QueryRow(query, username)
-	return scanIntoAccount(row)
+	attempt, err := scanIntoLoginAttempt(s.queryRow(ctx, query, key))
+	if err == sql.ErrNoRows {
+		return &LoginAttempt{Key: key}, nil
+	}
+	return attempt, err
 }
 
-func (s *PostgresStore) UpdateAccount(acc *Account) error {
-	return nil
+// RecordLoginFailure increments the counter atomically so concurrent
+// instances never lose a failure. An expired lockout starts a fresh count.
+func (s *sqlStore) RecordLoginFailure(ctx context.Context, key string, at time.Time) (*LoginAttempt, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	query := `
+INSERT INTO login_attempts (key, failures, last_failure)
+VALUES ($1, 1, $2)
+ON CONFLICT (key) DO UPDATE SET
+    failures = CASE WHEN login_attempts.locked_until <= $2 THEN 1 ELSE login_attempts.failures + 1 END,
+    locked_until = CASE WHEN login_attempts.locked_until <= $2 THEN NULL ELSE login_attempts.locked_until END,
+    last_failure = $2
+RETURNING key, failures, last_failure, locked_until
+    `
+	return scanIntoLoginAttempt(s.queryRow(ctx, query, key, at.UTC()))
 }
 
-func (s *PostgresStore) DeleteAccount(id int) error {
+func (s *sqlStore) LockLogin(ctx context.Context, key string, until time.Time) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
 	query := `
-    delete  from accounts where id=$1
+    update login_attempts set locked_until = $2 where key = $1
     `
-	_, err := s.db.Exec(query, id)
+	_, err := s.exec(ctx, query, key, until.UTC())
 	return err
 }
 
-func (s *PostgresStore) AddTeamToFavourite(accountId int, abbr string) error {
+func (s *sqlStore) ResetLoginAttempts(ctx context.Context, key string) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
 	query := `
-INSERT INTO account_teams(account_id,team_abbr)
-VALUES ($1,$2);
+    delete from login_attempts where key = $1
     `
-	_, err := s.db.Exec(query, accountId, abbr)
+	_, err := s.exec(ctx, query, key)
+	return err
+}
+
+func (s *sqlStore) CreateSession(ctx context.Context, session *Session) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	query := `
+INSERT INTO sessions (account_id, user_agent, ip, created_at, last_seen_at)
+VALUES ($1,$2,$3,$4,$5)
+RETURNING id;
+    `
+	return s.queryRow(ctx, query, session.AccountId, session.UserAgent, session.IP, session.CreatedAt.UTC(), session.LastSeenAt.UTC()).Scan(&session.Id)
+}
+
+func (s *sqlStore) GetSessionById(ctx context.Context, id int) (*Session, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	query := `
+    select id, account_id, user_agent, ip, created_at, last_seen_at, revoked_at is not null from sessions where id = $1
+    `
+	session := &Session{}
+	err := scanIntoSession(s.queryRow(ctx, query, id), session)
+	if err == sql.ErrNoRows {
+		return nil, ErrSessionNotFound
+	}
 	if err != nil {
-		return err
+		return nil, err
 	}
+	return session, nil
+}
 
-	return nil
+func (s *sqlStore) GetAccountSessions(ctx context.Context, accountId int, page *PageRequest) ([]*Session, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	query, args := page.sqlClause(`
+    select id, account_id, user_agent, ip, created_at, last_seen_at, revoked_at is not null from sessions
+    where account_id = $1 and revoked_at is null`, []any{accountId})
+	rows, err := s.query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	sessions := []*Session{}
+	for rows.Next() {
+		session := &Session{}
+		if err := scanIntoSession(rows, session); err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, session)
+	}
+	return sessions, rows.Err()
 }
 
-func (s *PostgresStore) GetAccountFavouriteTeams(accountId int) ([]*Team, error) {
+// GetAllAccountSessions returns every session of the account, revoked ones
+// included, for the data export.
+func (s *sqlStore) GetAllAccountSessions(ctx context.Context, accountId int) ([]*Session, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
 	query := `
-    select name, abbr from account_teams join teams on account_teams.team_abbr = teams.abbr where account_id =$1    `
-	rows, err := s.db.Query(query, accountId)
+    select id, account_id, user_agent, ip, created_at, last_seen_at, revoked_at is not null from sessions
+    where account_id = $1 order by id`
+	rows, err := s.query(ctx, query, accountId)
 	if err != nil {
 		return nil, err
 	}
-	teams := []*Team{}
+	defer rows.Close()
+	sessions := []*Session{}
 	for rows.Next() {
-		team := &Team{}
-		err := rows.Scan(&team.Name, &team.Abbr)
-		if err != nil {
+		session := &Session{}
+		if err := scanIntoSession(rows, session); err != nil {
 			return nil, err
 		}
-		teams = append(teams, team)
+		sessions = append(sessions, session)
+	}
+	return sessions, rows.Err()
+}
+
+func (s *sqlStore) TouchSession(ctx context.Context, id int, at time.Time) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	query := `
+    update sessions set last_seen_at = $2 where id = $1
+    `
+	_, err := s.exec(ctx, query, id, at.UTC())
+	return err
+}
+
+func (s *sqlStore) RevokeSession(ctx context.Context, accountId int, id int) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	query := `
+    update sessions set revoked_at = CURRENT_TIMESTAMP where id = $1 and account_id = $2 and revoked_at is null
+    `
+	res, err := s.exec(ctx, query, id, accountId)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrSessionNotFound
 	}
-	return teams, nil
+	return err
 }
 
-func scanIntoAccount(r *sql.Row) (*Account, error) {
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func scanIntoSession(r scanner, session *Session) error {
+	return r.Scan(&session.Id, &session.AccountId, &session.UserAgent, &session.IP, &session.CreatedAt, &session.LastSeenAt, &session.Revoked)
+}
+
+func scanIntoLoginAttempt(r scanner) (*LoginAttempt, error) {
+	attempt := &LoginAttempt{}
+	var lastFailure, lockedUntil sql.NullTime
+	err := r.Scan(&attempt.Key, &attempt.Failures, &lastFailure, &lockedUntil)
+	if err != nil {
+		return nil, err
+	}
+	attempt.LastFailure = lastFailure.Time
+	attempt.LockedUntil = lockedUntil.Time
+	return attempt, nil
+}
+
+const accountColumns = `a.id, a.username, a.encrypted_password, a.created_at, a.is_admin,
+    a.timezone, a.time_format, a.updated_at, a.version`
+
+func scanIntoAccount(r scanner) (*Account, error) {
 	acc := &Account{}
-	err := r.Scan(&acc.Id, &acc.Username, &acc.EncryptedPassword, &acc.CreatedAt)
+	var updatedAt sql.NullTime
+	err := r.Scan(&acc.Id, &acc.Username, &acc.EncryptedPassword, &acc.CreatedAt, &acc.IsAdmin,
+		&acc.Timezone, &acc.TimeFormat, &updatedAt, &acc.Version)
+	acc.UpdatedAt = acc.CreatedAt
+	if updatedAt.Valid {
+		acc.UpdatedAt = updatedAt.Time
+	}
+	if err == sql.ErrNoRows {
+		return nil, ErrAccountNotFound
+	}
 	if err != nil {
-		if err == sql.ErrNoRows {
-			return nil, fmt.Errorf("No account found.")
-		}
-		fmt.Printf("Error while get account %s", err)
+		return nil, err
 	}
 	return acc, nil
 }
@@ -0,0 +1,775 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// storageBackends opens a fresh, empty store for each conformance test.
+// Postgres only runs when TEST_DATABASE_URL points at a scratch database,
+// whose tables are emptied before every test. The cached backend puts a
+// CachedStore in front of SQLite, so cached reads are held to the same
+// behaviour.
+var storageBackends = []struct {
+	name string
+	open func(t *testing.T) Storage
+}{
+	{"memory", func(t *testing.T) Storage { return NewMemoryStore() }},
+	{"sqlite", openTestSQLite},
+	{"postgres", openTestPostgres},
+	{"cached", func(t *testing.T) Storage { return NewCachedStore(openTestSQLite(t), NewLRUCache(100), time.Minute) }},
+}
+
+func openTestSQLite(t *testing.T) Storage {
+	store, err := NewSQLiteStore(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	if err := store.Init(); err != nil {
+		t.Fatal(err)
+	}
+	return store
+}
+
+func openTestPostgres(t *testing.T) Storage {
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+	store, err := NewPostgresStore(dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	if err := store.Init(); err != nil {
+		t.Fatal(err)
+	}
+	_, err = store.db.Exec(`truncate follows, account_identities, sessions, login_attempts, accounts, teams restart identity cascade`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store
+}
+
+// storageConformance is the behaviour every Storage must share, so the
+// memory and SQLite backends can stand in for Postgres.
+var storageConformance = []struct {
+	name string
+	run  func(t *testing.T, store Storage)
+}{
+	{"DuplicateUsernameConflicts", testDuplicateUsername},
+	{"FollowUnknownTeamNotFound", testFollowUnknownTeam},
+	{"UnknownAccountInvalid", testUnknownAccount},
+	{"DeleteAccountCascades", testDeleteAccountCascades},
+	{"UpdateStaleAccount", testUpdateStaleAccount},
+	{"FollowPositionsKeepGaps", testFollowPositions},
+	{"Accounts", testAccounts},
+	{"UpdateAccountConflicts", testUpdateAccountConflicts},
+	{"ValuesTooLong", testValuesTooLong},
+	{"Teams", testTeams},
+	{"FollowAndUnfollow", testFollowAndUnfollow},
+	{"ReplaceFollows", testReplaceFollows},
+	{"ReplaceFollowsIsAtomic", testReplaceFollowsAtomic},
+	{"FollowPagination", testFollowPagination},
+	{"Sessions", testSessions},
+	{"SessionPagination", testSessionPagination},
+	{"LoginAttempts", testLoginAttempts},
+	{"LoginLockoutExpires", testLoginLockoutExpires},
+	{"Identities", testIdentities},
+	{"Ping", testPing},
+}
+
+func TestStorageConformance(t *testing.T) {
+	for _, backend := range storageBackends {
+		t.Run(backend.name, func(t *testing.T) {
+			for _, tt := range storageConformance {
+				t.Run(tt.name, func(t *testing.T) {
+					tt.run(t, backend.open(t))
+				})
+			}
+		})
+	}
+}
+
+func wantKind(t *testing.T, err error, kind ErrorKind) {
+	t.Helper()
+	var e *Error
+	if !errors.As(err, &e) || e.Kind != kind {
+		t.Fatalf("err = %v, want a %s error", err, kind)
+	}
+}
+
+func mustCreateAccount(t *testing.T, store Storage, username string) *Account {
+	t.Helper()
+	acc := &Account{Username: username, EncryptedPassword: "x"}
+	if err := store.CreateAccount(context.Background(), acc); err != nil {
+		t.Fatal(err)
+	}
+	return acc
+}
+
+func mustAddTeams(t *testing.T, store Storage, teams ...Team) {
+	t.Helper()
+	for i := range teams {
+		if err := store.AddTeam(context.Background(), &teams[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func teamFollow(abbr string) *Follow {
+	return &Follow{FollowTarget: FollowTarget{Kind: FollowTeam, Ref: abbr}}
+}
+
+func testDuplicateUsername(t *testing.T, store Storage) {
+	mustCreateAccount(t, store, "alice")
+	err := store.CreateAccount(context.Background(), &Account{Username: "alice", EncryptedPassword: "x"})
+	wantKind(t, err, KindConflict)
+}
+
+func testFollowUnknownTeam(t *testing.T, store Storage) {
+	acc := mustCreateAccount(t, store, "alice")
+	_, err := store.Follow(context.Background(), acc.Id, teamFollow("XXX"))
+	wantKind(t, err, KindNotFound)
+}
+
+// testUnknownAccount writes rows that reference a missing account, which
+// every backend reports as a validation error rather than a conflict.
+func testUnknownAccount(t *testing.T, store Storage) {
+	ctx := context.Background()
+	mustAddTeams(t, store, nbaTeams[0])
+	missing := 999
+	_, err := store.Follow(ctx, missing, teamFollow(nbaTeams[0].Abbr))
+	wantKind(t, err, KindValidation)
+	wantKind(t, store.ReplaceFollows(ctx, missing, FollowTeam, []*Follow{teamFollow(nbaTeams[0].Abbr)}), KindValidation)
+	wantKind(t, store.CreateSession(ctx, NewSession(missing, "test", "192.0.2.1")), KindValidation)
+	wantKind(t, store.LinkIdentity(ctx, missing, "google", "123"), KindValidation)
+}
+
+func testDeleteAccountCascades(t *testing.T, store Storage) {
+	ctx := context.Background()
+	acc := mustCreateAccount(t, store, "alice")
+	mustAddTeams(t, store, nbaTeams[0])
+	if _, err := store.Follow(ctx, acc.Id, teamFollow(nbaTeams[0].Abbr)); err != nil {
+		t.Fatal(err)
+	}
+	session := NewSession(acc.Id, "test", "127.0.0.1")
+	revoked := NewSession(acc.Id, "test", "127.0.0.1")
+	for _, s := range []*Session{session, revoked} {
+		if err := store.CreateSession(ctx, s); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := store.RevokeSession(ctx, acc.Id, revoked.Id); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.LinkIdentity(ctx, acc.Id, "dev", "alice"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.DeleteAccount(ctx, acc.Id); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.GetAccountById(ctx, acc.Id); !errors.Is(err, ErrAccountNotFound) {
+		t.Errorf("GetAccountById err = %v, want ErrAccountNotFound", err)
+	}
+	if follows, err := store.GetFollows(ctx, acc.Id, "", followList.All()); err != nil || len(follows) != 0 {
+		t.Errorf("follows = %v, %v, want none", follows, err)
+	}
+	for _, s := range []*Session{session, revoked} {
+		if _, err := store.GetSessionById(ctx, s.Id); !errors.Is(err, ErrSessionNotFound) {
+			t.Errorf("GetSessionById(%d) err = %v, want ErrSessionNotFound", s.Id, err)
+		}
+	}
+	if sessions, err := store.GetAllAccountSessions(ctx, acc.Id); err != nil || len(sessions) != 0 {
+		t.Errorf("sessions = %v, %v, want none", sessions, err)
+	}
+	if _, err := store.GetAccountByIdentity(ctx, "dev", "alice"); !errors.Is(err, ErrAccountNotFound) {
+		t.Errorf("GetAccountByIdentity err = %v, want ErrAccountNotFound", err)
+	}
+	if identities, err := store.GetAccountIdentities(ctx, acc.Id); err != nil || len(identities) != 0 {
+		t.Errorf("identities = %v, %v, want none", identities, err)
+	}
+
+	// The username and identity are free again.
+	again := mustCreateAccount(t, store, "alice")
+	if err := store.LinkIdentity(ctx, again.Id, "dev", "alice"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.DeleteAccount(ctx, acc.Id); !errors.Is(err, ErrAccountNotFound) {
+		t.Errorf("second DeleteAccount err = %v, want ErrAccountNotFound", err)
+	}
+}
+
+func testUpdateStaleAccount(t *testing.T, store Storage) {
+	ctx := context.Background()
+	acc := mustCreateAccount(t, store, "alice")
+	first, err := store.GetAccountById(ctx, acc.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := store.GetAccountById(ctx, acc.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first.Timezone = "Europe/Paris"
+	if err := store.UpdateAccount(ctx, first); err != nil {
+		t.Fatal(err)
+	}
+	second.Timezone = "America/New_York"
+	if err := store.UpdateAccount(ctx, second); !errors.Is(err, ErrStaleAccount) {
+		t.Fatalf("err = %v, want ErrStaleAccount", err)
+	}
+	got, err := store.GetAccountById(ctx, acc.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Timezone != "Europe/Paris" || got.Version != first.Version {
+		t.Errorf("stored timezone %q version %d, want Europe/Paris version %d", got.Timezone, got.Version, first.Version)
+	}
+}
+
+func testFollowPositions(t *testing.T, store Storage) {
+	ctx := context.Background()
+	acc := mustCreateAccount(t, store, "alice")
+	mustAddTeams(t, store, nbaTeams[:4]...)
+	for _, team := range nbaTeams[:3] {
+		if _, err := store.Follow(ctx, acc.Id, teamFollow(team.Abbr)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := store.Unfollow(ctx, acc.Id, FollowTarget{Kind: FollowTeam, Ref: nbaTeams[1].Abbr}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Follow(ctx, acc.Id, teamFollow(nbaTeams[3].Abbr)); err != nil {
+		t.Fatal(err)
+	}
+	follows, err := store.GetFollows(ctx, acc.Id, FollowTeam, followList.All())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]int{nbaTeams[0].Abbr: 1, nbaTeams[2].Abbr: 3, nbaTeams[3].Abbr: 4}
+	if len(follows) != len(want) {
+		t.Fatalf("got %d follows, want %d", len(follows), len(want))
+	}
+	for _, f := range follows {
+		if f.Position != want[f.Ref] {
+			t.Errorf("%s at position %d, want %d", f.Ref, f.Position, want[f.Ref])
+		}
+	}
+}
+
+func testAccounts(t *testing.T, store Storage) {
+	ctx := context.Background()
+	acc := mustCreateAccount(t, store, "alice")
+	if acc.Id == 0 || acc.Version != 1 || acc.Timezone != "UTC" || acc.TimeFormat != "24h" || acc.CreatedAt.IsZero() {
+		t.Fatalf("created account = %+v, want an id, version 1 and default preferences", acc)
+	}
+	byId, err := store.GetAccountById(ctx, acc.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	byName, err := store.GetAccountByUsername(ctx, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if byId.Id != acc.Id || byName.Id != acc.Id || byName.EncryptedPassword != "x" || byName.IsAdmin {
+		t.Errorf("lookups = %+v and %+v, want account %d", byId, byName, acc.Id)
+	}
+	if _, err := store.GetAccountById(ctx, acc.Id+100); !errors.Is(err, ErrAccountNotFound) {
+		t.Errorf("unknown id err = %v, want ErrAccountNotFound", err)
+	}
+	if _, err := store.GetAccountByUsername(ctx, "bob"); !errors.Is(err, ErrAccountNotFound) {
+		t.Errorf("unknown username err = %v, want ErrAccountNotFound", err)
+	}
+
+	byId.Username = "alicia"
+	byId.Timezone = "Europe/Paris"
+	byId.TimeFormat = "12h"
+	if err := store.UpdateAccount(ctx, byId); err != nil {
+		t.Fatal(err)
+	}
+	if byId.Version != 2 {
+		t.Errorf("version after update = %d, want 2", byId.Version)
+	}
+	got, err := store.GetAccountByUsername(ctx, "alicia")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Id != acc.Id || got.Timezone != "Europe/Paris" || got.TimeFormat != "12h" || got.Version != 2 {
+		t.Errorf("stored account = %+v", got)
+	}
+	if _, err := store.GetAccountByUsername(ctx, "alice"); !errors.Is(err, ErrAccountNotFound) {
+		t.Errorf("old username err = %v, want ErrAccountNotFound", err)
+	}
+}
+
+func testUpdateAccountConflicts(t *testing.T, store Storage) {
+	ctx := context.Background()
+	mustCreateAccount(t, store, "alice")
+	bob := mustCreateAccount(t, store, "bob")
+	bob.Username = "alice"
+	wantKind(t, store.UpdateAccount(ctx, bob), KindConflict)
+	missing := &Account{Id: bob.Id + 100, Username: "carol", Version: 1}
+	if err := store.UpdateAccount(ctx, missing); !errors.Is(err, ErrAccountNotFound) {
+		t.Errorf("updating an unknown account err = %v, want ErrAccountNotFound", err)
+	}
+	if err := store.DeleteAccount(ctx, bob.Id+100); !errors.Is(err, ErrAccountNotFound) {
+		t.Errorf("deleting an unknown account err = %v, want ErrAccountNotFound", err)
+	}
+}
+
+func testValuesTooLong(t *testing.T, store Storage) {
+	ctx := context.Background()
+	err := store.CreateAccount(ctx, &Account{Username: strings.Repeat("a", 51), EncryptedPassword: "x"})
+	wantKind(t, err, KindValidation)
+	wantKind(t, store.AddTeam(ctx, &Team{Name: "Too Long", Abbr: "ABCD"}), KindValidation)
+}
+
+func testTeams(t *testing.T, store Storage) {
+	ctx := context.Background()
+	if teams, err := store.GetTeams(ctx); err != nil || len(teams) != 0 {
+		t.Fatalf("teams of an empty store = %v, %v", teams, err)
+	}
+	mustAddTeams(t, store, nbaTeams[2], nbaTeams[0], nbaTeams[1])
+	wantKind(t, store.AddTeam(ctx, &Team{Name: "Again", Abbr: nbaTeams[0].Abbr}), KindConflict)
+	teams, err := store.GetTeams(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Team{nbaTeams[2], nbaTeams[0], nbaTeams[1]}
+	if len(teams) != len(want) {
+		t.Fatalf("got %d teams, want %d", len(teams), len(want))
+	}
+	for i, team := range teams {
+		if *team != want[i] {
+			t.Errorf("team %d = %+v, want %+v in insertion order", i, *team, want[i])
+		}
+	}
+}
+
+func testFollowAndUnfollow(t *testing.T, store Storage) {
+	ctx := context.Background()
+	acc := mustCreateAccount(t, store, "alice")
+	mustAddTeams(t, store, nbaTeams[0])
+	team := teamFollow(nbaTeams[0].Abbr)
+	team.Notifications = FollowNotifications{TipOff: true, ScheduleChange: true}
+	game := &Follow{FollowTarget: FollowTarget{Kind: FollowGame, Ref: "0022300001"}}
+	for _, f := range []*Follow{team, game} {
+		added, err := store.Follow(ctx, acc.Id, f)
+		if err != nil || !added {
+			t.Fatalf("Follow(%v) = %v, %v, want added", f.FollowTarget, added, err)
+		}
+	}
+	if added, err := store.Follow(ctx, acc.Id, teamFollow(nbaTeams[0].Abbr)); err != nil || added {
+		t.Fatalf("following twice = %v, %v, want a no-op", added, err)
+	}
+
+	all, err := store.GetFollows(ctx, acc.Id, "", followList.All())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 2 {
+		t.Fatalf("got %d follows, want 2", len(all))
+	}
+	// The default order is by kind, so the game comes first.
+	if all[0].FollowTarget != game.FollowTarget || all[0].Team != nil || all[0].Position != 1 {
+		t.Errorf("game follow = %+v", all[0])
+	}
+	if got := all[1]; got.FollowTarget != team.FollowTarget || got.Position != 1 ||
+		got.Team == nil || *got.Team != nbaTeams[0] || got.Notifications != team.Notifications || got.CreatedAt.IsZero() {
+		t.Errorf("team follow = %+v", got)
+	}
+	teams, err := store.GetFollows(ctx, acc.Id, FollowTeam, followList.All())
+	if err != nil || len(teams) != 1 || teams[0].Kind != FollowTeam {
+		t.Fatalf("team follows = %v, %v, want only the team", teams, err)
+	}
+
+	if err := store.Unfollow(ctx, acc.Id, game.FollowTarget); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Unfollow(ctx, acc.Id, game.FollowTarget); !errors.Is(err, ErrFollowNotFound) {
+		t.Errorf("unfollowing twice err = %v, want ErrFollowNotFound", err)
+	}
+	other := mustCreateAccount(t, store, "bob")
+	if err := store.Unfollow(ctx, other.Id, team.FollowTarget); !errors.Is(err, ErrFollowNotFound) {
+		t.Errorf("unfollowing another account's follow err = %v, want ErrFollowNotFound", err)
+	}
+	if follows, err := store.GetFollows(ctx, acc.Id, "", followList.All()); err != nil || len(follows) != 1 {
+		t.Errorf("follows after unfollow = %v, %v, want the team", follows, err)
+	}
+}
+
+func followRefs(follows []*Follow) []string {
+	refs := []string{}
+	for _, f := range follows {
+		refs = append(refs, fmt.Sprintf("%s:%s@%d", f.Kind, f.Ref, f.Position))
+	}
+	return refs
+}
+
+func testReplaceFollows(t *testing.T, store Storage) {
+	ctx := context.Background()
+	acc := mustCreateAccount(t, store, "alice")
+	mustAddTeams(t, store, nbaTeams[:3]...)
+	for _, f := range []*Follow{teamFollow(nbaTeams[0].Abbr), teamFollow(nbaTeams[1].Abbr), {FollowTarget: FollowTarget{Kind: FollowPlayer, Ref: "2544"}}} {
+		if _, err := store.Follow(ctx, acc.Id, f); err != nil {
+			t.Fatal(err)
+		}
+	}
+	replacement := []*Follow{teamFollow(nbaTeams[2].Abbr), teamFollow(nbaTeams[0].Abbr)}
+	replacement[0].Notifications.FinalScore = true
+	if err := store.ReplaceFollows(ctx, acc.Id, FollowTeam, replacement); err != nil {
+		t.Fatal(err)
+	}
+	follows, err := store.GetFollows(ctx, acc.Id, "", followList.All())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"player:2544@1", "team:" + nbaTeams[2].Abbr + "@1", "team:" + nbaTeams[0].Abbr + "@2"}
+	if got := followRefs(follows); !reflect.DeepEqual(got, want) {
+		t.Fatalf("follows = %v, want %v", got, want)
+	}
+	if !follows[1].Notifications.FinalScore || follows[2].Notifications.FinalScore {
+		t.Errorf("notifications were not replaced: %+v, %+v", follows[1].Notifications, follows[2].Notifications)
+	}
+
+	if err := store.ReplaceFollows(ctx, acc.Id, FollowTeam, nil); err != nil {
+		t.Fatal(err)
+	}
+	follows, err = store.GetFollows(ctx, acc.Id, "", followList.All())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := followRefs(follows); !reflect.DeepEqual(got, []string{"player:2544@1"}) {
+		t.Errorf("follows after clearing teams = %v, want only the player", got)
+	}
+}
+
+func testReplaceFollowsAtomic(t *testing.T, store Storage) {
+	ctx := context.Background()
+	acc := mustCreateAccount(t, store, "alice")
+	mustAddTeams(t, store, nbaTeams[:2]...)
+	if _, err := store.Follow(ctx, acc.Id, teamFollow(nbaTeams[0].Abbr)); err != nil {
+		t.Fatal(err)
+	}
+	unknown := []*Follow{teamFollow(nbaTeams[1].Abbr), teamFollow("XXX")}
+	wantKind(t, store.ReplaceFollows(ctx, acc.Id, FollowTeam, unknown), KindNotFound)
+	duplicate := []*Follow{teamFollow(nbaTeams[1].Abbr), teamFollow(nbaTeams[1].Abbr)}
+	wantKind(t, store.ReplaceFollows(ctx, acc.Id, FollowTeam, duplicate), KindConflict)
+	follows, err := store.GetFollows(ctx, acc.Id, "", followList.All())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := followRefs(follows), []string{"team:" + nbaTeams[0].Abbr + "@1"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("follows after failed replaces = %v, want %v", got, want)
+	}
+}
+
+// pageThrough lists every item by following cursors limit items at a time.
+func pageThrough[T any](t *testing.T, spec *ListSpec, sort string, limit int, list func(*PageRequest) ([]T, error)) []T {
+	t.Helper()
+	var all []T
+	cursor := ""
+	for pages := 0; ; pages++ {
+		if pages > 100 {
+			t.Fatalf("sort %q: cursors never reached the last page", sort)
+		}
+		page, err := spec.page(limit, sort, cursor, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		items, err := list(page)
+		if err != nil {
+			t.Fatal(err)
+		}
+		items, cursor = nextPage(page, items)
+		if len(items) > limit {
+			t.Fatalf("sort %q: page of %d items, limit %d", sort, len(items), limit)
+		}
+		all = append(all, items...)
+		if cursor == "" {
+			return all
+		}
+	}
+}
+
+func testFollowPagination(t *testing.T, store Storage) {
+	ctx := context.Background()
+	acc := mustCreateAccount(t, store, "alice")
+	mustAddTeams(t, store, nbaTeams[:5]...)
+	// Follow in an order that differs from ref order, with kinds mixed, so
+	// every sort has ties to break.
+	targets := []FollowTarget{
+		{Kind: FollowTeam, Ref: nbaTeams[3].Abbr}, {Kind: FollowGame, Ref: "g2"},
+		{Kind: FollowTeam, Ref: nbaTeams[0].Abbr}, {Kind: FollowPlayer, Ref: "p1"},
+		{Kind: FollowTeam, Ref: nbaTeams[4].Abbr}, {Kind: FollowGame, Ref: "g1"},
+		{Kind: FollowTeam, Ref: nbaTeams[1].Abbr}, {Kind: FollowTeam, Ref: nbaTeams[2].Abbr},
+	}
+	for _, target := range targets {
+		if _, err := store.Follow(ctx, acc.Id, &Follow{FollowTarget: target}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	list := func(page *PageRequest) ([]*Follow, error) { return store.GetFollows(ctx, acc.Id, "", page) }
+	for _, sort := range []string{"kind,position", "kind", "-kind", "position", "-position", "ref", "-ref,kind"} {
+		all, err := followList.page(0, sort, "", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want, err := list(all)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(want) != len(targets) {
+			t.Fatalf("sort %q: got %d follows, want %d", sort, len(want), len(targets))
+		}
+		for i := 1; i < len(want); i++ {
+			if all.compare(sortValues(want[i-1], all), sortValues(want[i], all)) >= 0 {
+				t.Fatalf("sort %q: %v is not strictly ordered", sort, followRefs(want))
+			}
+		}
+		for _, limit := range []int{1, 2, 3} {
+			got := pageThrough(t, followList, sort, limit, list)
+			if !reflect.DeepEqual(followRefs(got), followRefs(want)) {
+				t.Errorf("sort %q limit %d: paged %v, want %v", sort, limit, followRefs(got), followRefs(want))
+			}
+		}
+	}
+}
+
+func testSessions(t *testing.T, store Storage) {
+	ctx := context.Background()
+	alice := mustCreateAccount(t, store, "alice")
+	bob := mustCreateAccount(t, store, "bob")
+	first := NewSession(alice.Id, "Firefox", "192.0.2.1")
+	second := NewSession(alice.Id, "Safari", "2001:db8::1")
+	other := NewSession(bob.Id, "curl", "192.0.2.2")
+	for _, session := range []*Session{first, second, other} {
+		if err := store.CreateSession(ctx, session); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if first.Id == 0 || first.Id == second.Id {
+		t.Fatalf("session ids %d and %d, want distinct ids", first.Id, second.Id)
+	}
+	got, err := store.GetSessionById(ctx, second.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.AccountId != alice.Id || got.UserAgent != "Safari" || got.IP != "2001:db8::1" || got.Revoked ||
+		!got.CreatedAt.Truncate(time.Second).Equal(second.CreatedAt.Truncate(time.Second)) {
+		t.Errorf("session = %+v, want %+v", got, second)
+	}
+	if _, err := store.GetSessionById(ctx, other.Id+100); !errors.Is(err, ErrSessionNotFound) {
+		t.Errorf("unknown session err = %v, want ErrSessionNotFound", err)
+	}
+
+	seen := time.Now().Add(time.Hour).Truncate(time.Second)
+	if err := store.TouchSession(ctx, first.Id, seen); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := store.GetSessionById(ctx, first.Id); err != nil || !got.LastSeenAt.Equal(seen) {
+		t.Errorf("last seen after touch = %v, %v, want %s", got, err, seen)
+	}
+
+	if err := store.RevokeSession(ctx, bob.Id, first.Id); !errors.Is(err, ErrSessionNotFound) {
+		t.Errorf("revoking another account's session err = %v, want ErrSessionNotFound", err)
+	}
+	if err := store.RevokeSession(ctx, alice.Id, first.Id); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.RevokeSession(ctx, alice.Id, first.Id); !errors.Is(err, ErrSessionNotFound) {
+		t.Errorf("revoking twice err = %v, want ErrSessionNotFound", err)
+	}
+	if got, err := store.GetSessionById(ctx, first.Id); err != nil || !got.Revoked {
+		t.Errorf("revoked session = %+v, %v, want Revoked", got, err)
+	}
+	sessions, err := store.GetAccountSessions(ctx, alice.Id, sessionList.All())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sessions) != 1 || sessions[0].Id != second.Id {
+		t.Errorf("active sessions = %v, want only %d", sessions, second.Id)
+	}
+	all, err := store.GetAllAccountSessions(ctx, alice.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 2 || all[0].Id != first.Id || !all[0].Revoked || all[1].Id != second.Id || all[1].Revoked {
+		t.Errorf("all sessions = %+v, want %d revoked and %d live", all, first.Id, second.Id)
+	}
+}
+
+func testSessionPagination(t *testing.T, store Storage) {
+	ctx := context.Background()
+	acc := mustCreateAccount(t, store, "alice")
+	// Pairs of sessions share timestamps, so only the id breaks the tie.
+	base := time.Now().Truncate(time.Second)
+	var ids []int
+	for i := 0; i < 7; i++ {
+		session := NewSession(acc.Id, "test", "192.0.2.1")
+		session.CreatedAt = base.Add(time.Duration(i/2) * time.Minute)
+		session.LastSeenAt = base.Add(time.Duration(3-i/2) * time.Minute)
+		if err := store.CreateSession(ctx, session); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, session.Id)
+	}
+	sessionIds := func(sessions []*Session) []int {
+		got := []int{}
+		for _, s := range sessions {
+			got = append(got, s.Id)
+		}
+		return got
+	}
+	list := func(page *PageRequest) ([]*Session, error) { return store.GetAccountSessions(ctx, acc.Id, page) }
+	tests := []struct {
+		sort string
+		want []int
+	}{
+		{"-lastSeenAt", ids},
+		{"createdAt", ids},
+		{"-createdAt", []int{ids[6], ids[4], ids[5], ids[2], ids[3], ids[0], ids[1]}},
+		{"-id", []int{ids[6], ids[5], ids[4], ids[3], ids[2], ids[1], ids[0]}},
+	}
+	for _, tt := range tests {
+		for _, limit := range []int{1, 2, 4} {
+			if got := sessionIds(pageThrough(t, sessionList, tt.sort, limit, list)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("sort %q limit %d: paged %v, want %v", tt.sort, limit, got, tt.want)
+			}
+		}
+	}
+}
+
+func testLoginAttempts(t *testing.T, store Storage) {
+	ctx := context.Background()
+	key := "user:alice"
+	attempt, err := store.GetLoginAttempt(ctx, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attempt.Key != key || attempt.Failures != 0 || !attempt.LockedUntil.IsZero() {
+		t.Fatalf("unknown key = %+v, want no failures", attempt)
+	}
+	// Locking a key with no failures is a no-op.
+	now := time.Now().Truncate(time.Second)
+	if err := store.LockLogin(ctx, key, now.Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i <= 3; i++ {
+		attempt, err := store.RecordLoginFailure(ctx, key, now.Add(time.Duration(i)*time.Second))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if attempt.Failures != i || !attempt.LockedUntil.IsZero() {
+			t.Fatalf("after failure %d = %+v", i, attempt)
+		}
+	}
+	if err := store.LockLogin(ctx, key, now.Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	attempt, err = store.GetLoginAttempt(ctx, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attempt.Failures != 3 || !attempt.LastFailure.Equal(now.Add(3*time.Second)) || !attempt.LockedUntil.Equal(now.Add(time.Hour)) {
+		t.Errorf("locked attempt = %+v", attempt)
+	}
+	// A failure during the lockout still counts.
+	if attempt, err = store.RecordLoginFailure(ctx, key, now.Add(time.Minute)); err != nil || attempt.Failures != 4 || attempt.LockedUntil.IsZero() {
+		t.Errorf("failure while locked = %+v, %v, want 4 failures and the lock kept", attempt, err)
+	}
+	if other, err := store.GetLoginAttempt(ctx, "ip:192.0.2.1"); err != nil || other.Failures != 0 {
+		t.Errorf("other key = %+v, %v, want untouched", other, err)
+	}
+
+	if err := store.ResetLoginAttempts(ctx, key); err != nil {
+		t.Fatal(err)
+	}
+	if attempt, err = store.GetLoginAttempt(ctx, key); err != nil || attempt.Failures != 0 || !attempt.LockedUntil.IsZero() {
+		t.Errorf("after reset = %+v, %v, want a fresh key", attempt, err)
+	}
+	if err := store.ResetLoginAttempts(ctx, key); err != nil {
+		t.Errorf("resetting an unknown key: %v", err)
+	}
+}
+
+func testLoginLockoutExpires(t *testing.T, store Storage) {
+	ctx := context.Background()
+	key := "ip:192.0.2.1"
+	now := time.Now().Truncate(time.Second)
+	for i := 0; i < 5; i++ {
+		if _, err := store.RecordLoginFailure(ctx, key, now); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := store.LockLogin(ctx, key, now.Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	attempt, err := store.RecordLoginFailure(ctx, key, now.Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attempt.Failures != 1 || !attempt.LockedUntil.IsZero() || !attempt.LastFailure.Equal(now.Add(time.Minute)) {
+		t.Errorf("first failure after the lockout = %+v, want a fresh count", attempt)
+	}
+}
+
+func testIdentities(t *testing.T, store Storage) {
+	ctx := context.Background()
+	alice := mustCreateAccount(t, store, "alice")
+	bob := mustCreateAccount(t, store, "bob")
+	if _, err := store.GetAccountByIdentity(ctx, "google", "123"); !errors.Is(err, ErrAccountNotFound) {
+		t.Errorf("unlinked identity err = %v, want ErrAccountNotFound", err)
+	}
+	if err := store.LinkIdentity(ctx, alice.Id, "google", "123"); err != nil {
+		t.Fatal(err)
+	}
+	// The same subject at another provider is a different identity.
+	if err := store.LinkIdentity(ctx, bob.Id, "github", "123"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.LinkIdentity(ctx, alice.Id, "github", "456"); err != nil {
+		t.Fatal(err)
+	}
+	wantKind(t, store.LinkIdentity(ctx, bob.Id, "google", "123"), KindConflict)
+	wantKind(t, store.LinkIdentity(ctx, alice.Id, "google", "123"), KindConflict)
+
+	for _, tt := range []struct {
+		provider, subject string
+		want              int
+	}{{"google", "123", alice.Id}, {"github", "123", bob.Id}, {"github", "456", alice.Id}} {
+		acc, err := store.GetAccountByIdentity(ctx, tt.provider, tt.subject)
+		if err != nil || acc.Id != tt.want {
+			t.Errorf("%s/%s = %v, %v, want account %d", tt.provider, tt.subject, acc, err, tt.want)
+		}
+	}
+	identities, err := store.GetAccountIdentities(ctx, alice.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var linked []string
+	for _, identity := range identities {
+		if identity.AccountId != alice.Id || identity.CreatedAt.IsZero() {
+			t.Errorf("identity = %+v", identity)
+		}
+		linked = append(linked, identity.Provider+"/"+identity.Subject)
+	}
+	if !reflect.DeepEqual(linked, []string{"google/123", "github/456"}) && !reflect.DeepEqual(linked, []string{"github/456", "google/123"}) {
+		t.Errorf("identities = %v, want google/123 and github/456", linked)
+	}
+}
+
+func testPing(t *testing.T, store Storage) {
+	if err := store.Ping(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
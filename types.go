@@ -1,32 +1,124 @@
 package main
 
 import (
+	"strings"
 	"time"
 
 	"golang.org/x/crypto/bcrypt"
 )
 
 type Auth struct {
-	Username string `json:"username"`
-	Password string `json:"password"`
+	Username string `json:"username" validate:"trim,required"`
+	Password string `json:"password" validate:"required"`
 }
 type (
-	RegisterRequest struct{ Auth }
-	LoginRequest    struct {
+	RegisterRequest struct {
+		Username string `json:"username" validate:"trim,username"`
+		Password string `json:"password" validate:"password"`
+	}
+	LoginRequest struct {
 		Auth
 	}
 )
 
 type AddToFavourite struct {
-	Abbr string `json:"abbr"`
+	Abbr string `json:"abbr" validate:"required,upper,abbr"`
+}
+
+// FollowKind is the type of entity a follow points at.
+type FollowKind string
+
+const (
+	FollowTeam   FollowKind = "team"
+	FollowGame   FollowKind = "game"
+	FollowPlayer FollowKind = "player"
+)
+
+// FollowTarget identifies a followed entity. Ref is a team abbreviation,
+// or a game or player id.
+type FollowTarget struct {
+	Kind FollowKind `json:"kind" validate:"oneof=team game player"`
+	Ref  string     `json:"ref" validate:"required,max=64"`
+}
+
+// normalise upper cases team refs, which are abbreviations, and checks them
+// like Team.Abbr. Game and player refs are ids and are kept as sent.
+func (t *FollowTarget) normalise() error {
+	if t.Kind != FollowTeam {
+		return nil
+	}
+	t.Ref = strings.ToUpper(t.Ref)
+	if !abbrPattern.MatchString(t.Ref) {
+		return invalidFields(nil, FieldError{Field: "ref", Message: "must be a three letter team abbreviation"})
+	}
+	return nil
+}
+
+func (k FollowKind) Valid() bool {
+	return k == FollowTeam || k == FollowGame || k == FollowPlayer
+}
+
+// FollowNotifications selects the game events a follow notifies about.
+type FollowNotifications struct {
+	TipOff         bool `json:"tipOff"`
+	FinalScore     bool `json:"finalScore"`
+	ScheduleChange bool `json:"scheduleChange"`
+}
+
+// Follow is an entity on an account's follow list. Follows of each kind
+// are ordered by Position, starting at 1. Team is set for team follows.
+type Follow struct {
+	FollowTarget
+	Team          *Team               `json:"team,omitempty"`
+	Position      int                 `json:"position"`
+	Notifications FollowNotifications `json:"notifications"`
+	CreatedAt     time.Time           `json:"createdAt"`
 }
+
+// Favourite is the team view of a follow served by /teams and
+// /me/favourites.
+type Favourite struct {
+	Team
+	Position      int                 `json:"position"`
+	Notifications FollowNotifications `json:"notifications"`
+}
+
+func favouritesFromFollows(follows []*Follow) []*Favourite {
+	favourites := make([]*Favourite, 0, len(follows))
+	for _, follow := range follows {
+		fav := &Favourite{Team: Team{Abbr: follow.Ref}, Position: follow.Position, Notifications: follow.Notifications}
+		if follow.Team != nil {
+			fav.Team = *follow.Team
+		}
+		favourites = append(favourites, fav)
+	}
+	return favourites
+}
+
 type Account struct {
 	Id                int       `json:"id" `
 	Username          string    `json:"username" `
 	EncryptedPassword string    `json:"-" `
 	CreatedAt         time.Time `json:"createdAt" `
+	IsAdmin           bool      `json:"isAdmin" `
+	Timezone          string    `json:"timezone" `
+	TimeFormat        string    `json:"timeFormat" `
+	UpdatedAt         time.Time `json:"updatedAt" `
+	Version           int       `json:"version" `
 	// FavouriteTeams []Team
 }
+
+// UpdateAccountRequest is a partial update: nil fields are left unchanged.
+// Version must match the stored version, otherwise the update is rejected
+// so concurrent edits are never silently overwritten.
+type UpdateAccountRequest struct {
+	Username        *string `json:"username" validate:"trim,username"`
+	Password        *string `json:"password" validate:"password"`
+	CurrentPassword string  `json:"currentPassword"`
+	Timezone        *string `json:"timezone" validate:"timezone"`
+	TimeFormat      *string `json:"timeFormat" validate:"oneof=12h 24h"`
+	Version         int     `json:"version" validate:"required,min=1"`
+}
 type CreateAccountRequest struct {
 	Username string
 	Timezone string
@@ -41,19 +133,110 @@ func (acc *Account) ValidateAccount(pw string) bool {
 }
 
 func NewAccount(username string, password string) (*Account, error) {
+	acc := &Account{
+		Username:   username,
+		Timezone:   "UTC",
+		TimeFormat: "24h",
+	}
+	if err := acc.SetPassword(password); err != nil {
+		return nil, err
+	}
+	return acc, nil
+}
+
+func (acc *Account) SetPassword(password string) error {
 	encpw, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
 	if err != nil {
-		return nil, err
+		return err
+	}
+	acc.EncryptedPassword = string(encpw)
+	return nil
+}
+
+// UnlockRequest clears the lockout of a username, an IP address or both.
+type UnlockRequest struct {
+	Username *string `json:"username" validate:"trim,min=1,max=50"`
+	IP       *string `json:"ip" validate:"trim,ip"`
+}
+
+type LoginAttempt struct {
+	Key         string
+	Failures    int
+	LastFailure time.Time
+	LockedUntil time.Time
+}
+
+type Session struct {
+	Id         int       `json:"id"`
+	AccountId  int       `json:"-"`
+	UserAgent  string    `json:"userAgent"`
+	IP         string    `json:"ip"`
+	CreatedAt  time.Time `json:"createdAt"`
+	LastSeenAt time.Time `json:"lastSeenAt"`
+	Revoked    bool      `json:"-"`
+	Current    bool      `json:"current"`
+}
+
+func NewSession(accountId int, userAgent string, ip string) *Session {
+	now := time.Now()
+	return &Session{
+		AccountId:  accountId,
+		UserAgent:  userAgent,
+		IP:         ip,
+		CreatedAt:  now,
+		LastSeenAt: now,
+	}
+}
+
+// Identity is an external login linked to an account.
+type Identity struct {
+	AccountId int       `json:"-"`
+	Provider  string    `json:"provider"`
+	Subject   string    `json:"subject"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// AccountExport is everything stored about an account, as returned by
+// GET /me/export.
+type AccountExport struct {
+	ExportedAt    time.Time              `json:"exportedAt"`
+	Account       *Account               `json:"account"`
+	Follows       []*Follow              `json:"follows"`
+	Sessions      []*ExportedSession     `json:"sessions"`
+	Identities    []*Identity            `json:"identities"`
+	LoginAttempts *ExportedLoginAttempts `json:"loginAttempts"`
+}
+
+// ExportedSession shows whether a session was revoked. The sessions API
+// only lists live sessions, so Session leaves the flag out.
+type ExportedSession struct {
+	*Session
+	Revoked bool `json:"revoked"`
+}
+
+// ExportedLoginAttempts is the lockout state kept under the account's
+// username. Attempts counted per client IP are shared by everyone behind
+// that address, so they are not part of any one account's export.
+type ExportedLoginAttempts struct {
+	Failures    int        `json:"failures"`
+	LastFailure *time.Time `json:"lastFailure,omitempty"`
+	LockedUntil *time.Time `json:"lockedUntil,omitempty"`
+}
+
+func NewExportedLoginAttempts(a *LoginAttempt) *ExportedLoginAttempts {
+	e := &ExportedLoginAttempts{Failures: a.Failures}
+	if !a.LastFailure.IsZero() {
+		e.LastFailure = &a.LastFailure
+	}
+	if !a.LockedUntil.IsZero() {
+		e.LockedUntil = &a.LockedUntil
 	}
-	return &Account{
-		Username:          username,
-		EncryptedPassword: string(encpw),
-	}, nil
+	return e
 }
 
 type Team struct {
 	Name string `json:"name"`
-	Abbr string `json:"abbr"`
+	Abbr string `json:"abbr" validate:"required,upper,abbr"`
 }
 
 type Schedule struct {
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/netip"
+	"reflect"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// maxBodyBytes caps the size of every JSON request body.
+const maxBodyBytes = 1 << 20
+
+// FieldError reports why a single request field was rejected. Field is the
+// JSON path of the value, e.g. "[1].abbr" for the second element of a list.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+var (
+	usernamePattern = regexp.MustCompile(`^[A-Za-z0-9_.-]{3,50}$`)
+	abbrPattern     = regexp.MustCompile(`^[A-Z]{3}$`)
+)
+
+// BodyDecoder decodes the JSON request body into v and validates it. Bodies
+// larger than maxBodyBytes, unknown fields and trailing data are rejected.
+func BodyDecoder(w http.ResponseWriter, r *http.Request, v any) error {
+	body := http.MaxBytesReader(w, r.Body, maxBodyBytes)
+	defer body.Close()
+	dec := json.NewDecoder(body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return decodeError(err)
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return &Error{Kind: KindValidation, Message: "Request body must contain a single JSON value."}
+	}
+	return Validate(v)
+}
+
+func decodeError(err error) error {
+	var maxErr *http.MaxBytesError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &maxErr):
+		return &Error{Kind: KindTooLarge, Message: fmt.Sprintf("Request body must not exceed %d bytes.", maxErr.Limit), Err: err}
+	case errors.As(err, &typeErr):
+		return invalidFields(err, FieldError{Field: typeErr.Field, Message: "must be " + jsonTypeName(typeErr.Type)})
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		field, _ := strconv.Unquote(strings.TrimPrefix(err.Error(), "json: unknown field "))
+		return invalidFields(err, FieldError{Field: field, Message: "is not a known field"})
+	}
+	return &Error{Kind: KindValidation, Message: "Request body is not valid JSON.", Err: err}
+}
+
+// jsonTypeName names the JSON type a Go type is decoded from, so errors
+// never show Go type names to clients.
+func jsonTypeName(t reflect.Type) string {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch {
+	case t == timeType:
+		return "a string"
+	case t.Kind() == reflect.Struct || t.Kind() == reflect.Map:
+		return "an object"
+	case t.Kind() == reflect.Slice || t.Kind() == reflect.Array:
+		return "an array"
+	case t.Kind() == reflect.String:
+		return "a string"
+	case t.Kind() == reflect.Bool:
+		return "a boolean"
+	case t.Kind() >= reflect.Int && t.Kind() <= reflect.Float64:
+		return "a number"
+	}
+	return "a different type"
+}
+
+func invalidFields(err error, fields ...FieldError) *Error {
+	return &Error{Kind: KindValidation, Message: "The request has invalid fields.", Err: err, Fields: fields}
+}
+
+// Validate checks v against the rules in its validate struct tags and
+// returns every failing field at once. Rules are comma separated:
+//
+//	required   the value is not empty
+//	min=N      strings have at least N characters, numbers are at least N,
+//	           lists have at least N items
+//	max=N      the upper bound counterpart of min
+//	oneof=a b  the string is one of the space separated values
+//	username   3 to 50 letters, digits, '.', '_' or '-'
+//	password   8 to 72 characters, and at most the 72 bytes bcrypt hashes
+//	abbr       a three letter upper case team abbreviation
+//	timezone   an IANA time zone name
+//	ip         an IPv4 or IPv6 address
+//	trim       strip surrounding white space before checking
+//	upper      upper case the string before checking
+//
+// Nil pointers are only checked for required, so optional fields of partial
+// updates can be left out. Nested structs and lists of structs are walked.
+func Validate(v any) error {
+	var fields []FieldError
+	validateValue(reflect.ValueOf(v), "", &fields)
+	if len(fields) > 0 {
+		return invalidFields(nil, fields...)
+	}
+	return nil
+}
+
+func validateValue(v reflect.Value, path string, fields *[]FieldError) {
+	for v.Kind() == reflect.Pointer {
+		if v.IsNil() {
+			return
+		}
+		v = v.Elem()
+	}
+	switch v.Kind() {
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if !f.IsExported() {
+				continue
+			}
+			if f.Anonymous {
+				validateValue(v.Field(i), path, fields)
+				continue
+			}
+			name := fieldPath(path, f)
+			if tag := f.Tag.Get("validate"); tag != "" {
+				if msg := checkRules(v.Field(i), tag); msg != "" {
+					*fields = append(*fields, FieldError{Field: name, Message: msg})
+					continue
+				}
+			}
+			validateValue(v.Field(i), name, fields)
+		}
+	case reflect.Slice:
+		for i := 0; i < v.Len(); i++ {
+			validateValue(v.Index(i), fmt.Sprintf("%s[%d]", path, i), fields)
+		}
+	}
+}
+
+func fieldPath(path string, f reflect.StructField) string {
+	name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+	if name == "" {
+		name = f.Name
+	}
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+// checkRules applies the rules in tag to v and returns the first failure.
+func checkRules(v reflect.Value, tag string) string {
+	rules := strings.Split(tag, ",")
+	if v.Kind() == reflect.Pointer {
+		if v.IsNil() {
+			if slices.Contains(rules, "required") {
+				return "is required"
+			}
+			return ""
+		}
+		v = v.Elem()
+	}
+	for _, rule := range rules {
+		name, arg, _ := strings.Cut(rule, "=")
+		if msg := checkRule(v, name, arg); msg != "" {
+			return msg
+		}
+	}
+	return ""
+}
+
+func checkRule(v reflect.Value, rule string, arg string) string {
+	switch rule {
+	case "required":
+		if v.IsZero() || (v.Kind() == reflect.Slice && v.Len() == 0) {
+			return "is required"
+		}
+	case "trim":
+		v.SetString(strings.TrimSpace(v.String()))
+	case "upper":
+		v.SetString(strings.ToUpper(v.String()))
+	case "min", "max":
+		limit, err := strconv.Atoi(arg)
+		if err != nil {
+			panic(fmt.Sprintf("validate: bad %s limit %q", rule, arg))
+		}
+		return checkLimit(v, rule, limit)
+	case "oneof":
+		options := strings.Fields(arg)
+		if !slices.Contains(options, v.String()) {
+			return "must be one of " + strings.Join(options, ", ")
+		}
+	case "username":
+		if !usernamePattern.MatchString(v.String()) {
+			return "must be 3 to 50 letters, digits, '.', '_' or '-'"
+		}
+	case "password":
+		if n := utf8.RuneCountInString(v.String()); n < 8 || n > 72 {
+			return "must be between 8 and 72 characters"
+		}
+		if len(v.String()) > 72 {
+			return "must not exceed 72 bytes once UTF-8 encoded"
+		}
+	case "abbr":
+		if !abbrPattern.MatchString(v.String()) {
+			return "must be a three letter team abbreviation"
+		}
+	case "timezone":
+		if _, err := time.LoadLocation(v.String()); err != nil || v.String() == "" {
+			return "must be an IANA time zone such as Europe/Paris"
+		}
+	case "ip":
+		if _, err := netip.ParseAddr(v.String()); err != nil {
+			return "must be an IP address"
+		}
+	default:
+		panic(fmt.Sprintf("validate: unknown rule %q", rule))
+	}
+	return ""
+}
+
+func checkLimit(v reflect.Value, rule string, limit int) string {
+	var n int
+	var unit string
+	switch v.Kind() {
+	case reflect.String:
+		n, unit = utf8.RuneCountInString(v.String()), " characters"
+	case reflect.Slice:
+		n, unit = v.Len(), " items"
+	case reflect.Int, reflect.Int64:
+		n = int(v.Int())
+	default:
+		panic(fmt.Sprintf("validate: %s does not apply to %s", rule, v.Type()))
+	}
+	if rule == "min" && n < limit {
+		return fmt.Sprintf("must be at least %d%s", limit, unit)
+	}
+	if rule == "max" && n > limit {
+		return fmt.Sprintf("must be at most %d%s", limit, unit)
+	}
+	return ""
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// fieldErrors returns the field errors of a validation error, failing the
+// test for any other error.
+func fieldErrors(t *testing.T, err error) []FieldError {
+	t.Helper()
+	var e *Error
+	if !errors.As(err, &e) || e.Kind != KindValidation {
+		t.Fatalf("err = %v, want a validation error", err)
+	}
+	return e.Fields
+}
+
+func TestValidateRules(t *testing.T) {
+	type rules struct {
+		Required string   `json:"required" validate:"required"`
+		Min      string   `json:"min" validate:"min=2"`
+		Max      string   `json:"max" validate:"max=3"`
+		Items    []int    `json:"items" validate:"min=1"`
+		Number   int      `json:"number" validate:"min=1,max=9"`
+		OneOf    string   `json:"oneof" validate:"oneof=12h 24h"`
+		Username string   `json:"username" validate:"trim,username"`
+		Password string   `json:"password" validate:"password"`
+		Abbr     string   `json:"abbr" validate:"upper,abbr"`
+		Timezone string   `json:"timezone" validate:"timezone"`
+		IP       string   `json:"ip" validate:"trim,ip"`
+		Optional *string  `json:"optional" validate:"min=5"`
+		Needed   *string  `json:"needed" validate:"required"`
+		Nested   []*Team  `json:"nested"`
+		Skipped  []string `json:"skipped"`
+	}
+	needed := "x"
+	valid := func() *rules {
+		return &rules{
+			Required: "x", Min: "ab", Max: "abc", Items: []int{1}, Number: 5, OneOf: "24h",
+			Username: "alice", Password: "correct horse", Abbr: "BOS", Timezone: "Europe/Paris",
+			IP: "192.0.2.1", Needed: &needed,
+		}
+	}
+	if err := Validate(valid()); err != nil {
+		t.Fatalf("valid value: %v", err)
+	}
+
+	for _, tc := range []struct {
+		name   string
+		change func(*rules)
+		field  string
+	}{
+		{"required", func(r *rules) { r.Required = "" }, "required"},
+		{"min counts characters", func(r *rules) { r.Min = "é" }, "min"},
+		{"max counts characters", func(r *rules) { r.Max = "éééé" }, "max"},
+		{"min items", func(r *rules) { r.Items = nil }, "items"},
+		{"min number", func(r *rules) { r.Number = 0 }, "number"},
+		{"max number", func(r *rules) { r.Number = 10 }, "number"},
+		{"oneof", func(r *rules) { r.OneOf = "25h" }, "oneof"},
+		{"username", func(r *rules) { r.Username = "a b" }, "username"},
+		{"short password", func(r *rules) { r.Password = "short" }, "password"},
+		{"short multibyte password", func(r *rules) { r.Password = "ééééé" }, "password"},
+		{"long password", func(r *rules) { r.Password = strings.Repeat("a", 73) }, "password"},
+		{"password over bcrypt's bytes", func(r *rules) { r.Password = strings.Repeat("é", 37) }, "password"},
+		{"abbr", func(r *rules) { r.Abbr = "BO5" }, "abbr"},
+		{"timezone", func(r *rules) { r.Timezone = "Mars/Olympus" }, "timezone"},
+		{"empty timezone", func(r *rules) { r.Timezone = "" }, "timezone"},
+		{"ip", func(r *rules) { r.IP = "192.0.2" }, "ip"},
+		{"optional pointer", func(r *rules) { s := "abc"; r.Optional = &s }, "optional"},
+		{"nil required pointer", func(r *rules) { r.Needed = nil }, "needed"},
+		{"nested list", func(r *rules) { r.Nested = []*Team{{Abbr: "BOS"}, {Abbr: "B"}} }, "nested[1].abbr"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			v := valid()
+			tc.change(v)
+			fields := fieldErrors(t, Validate(v))
+			if len(fields) != 1 || fields[0].Field != tc.field {
+				t.Errorf("fields = %+v, want one error for %s", fields, tc.field)
+			}
+		})
+	}
+
+	// Eight characters pass even when they take more than eight bytes.
+	v := valid()
+	v.Password = "pässwörd"
+	if err := Validate(v); err != nil {
+		t.Errorf("multibyte password: %v", err)
+	}
+
+	// Every failing field is reported at once.
+	v = &rules{}
+	var got []string
+	for _, f := range fieldErrors(t, Validate(v)) {
+		got = append(got, f.Field)
+	}
+	want := []string{"required", "min", "items", "number", "oneof", "username", "password", "abbr", "timezone", "ip", "needed"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("fields = %v, want %v", got, want)
+	}
+}
+
+func TestValidateNormalises(t *testing.T) {
+	v := &struct {
+		Name string `validate:"trim,username"`
+		Abbr string `validate:"trim,upper,abbr"`
+	}{Name: "  alice\n", Abbr: " bos "}
+	if err := Validate(v); err != nil {
+		t.Fatal(err)
+	}
+	if v.Name != "alice" || v.Abbr != "BOS" {
+		t.Errorf("normalised = %q, %q, want alice, BOS", v.Name, v.Abbr)
+	}
+}
+
+func decodeRequest(body string, v any) error {
+	r := httptest.NewRequest("POST", "/", strings.NewReader(body))
+	return BodyDecoder(httptest.NewRecorder(), r, v)
+}
+
+func TestBodyDecoder(t *testing.T) {
+	var team Team
+	if err := decodeRequest(`{"name": "Celtics", "abbr": " bos "}`, &team); err == nil {
+		t.Fatal("untrimmed abbr was accepted")
+	}
+	if err := decodeRequest(`{"name": "Celtics", "abbr": "bos"}`, &team); err != nil || team.Abbr != "BOS" {
+		t.Errorf("decoded %+v, %v, want abbr BOS", team, err)
+	}
+
+	for _, tc := range []struct {
+		name  string
+		body  string
+		field string
+	}{
+		{"unknown field", `{"abbr": "BOS", "colour": "green"}`, "colour"},
+		{"wrong type", `{"abbr": 7}`, "abbr"},
+		{"rule", `{"abbr": "BOSTON"}`, "abbr"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			fields := fieldErrors(t, decodeRequest(tc.body, &Team{}))
+			if len(fields) != 1 || fields[0].Field != tc.field {
+				t.Errorf("fields = %+v, want one error for %s", fields, tc.field)
+			}
+		})
+	}
+	for _, body := range []string{``, `{"abbr": "BOS"} {}`, `{"abbr": "BOS"`} {
+		if err := decodeRequest(body, &Team{}); err == nil {
+			t.Errorf("body %q was accepted", body)
+		} else {
+			wantKind(t, err, KindValidation)
+		}
+	}
+
+	big := `{"name": "` + strings.Repeat("a", maxBodyBytes) + `", "abbr": "BOS"}`
+	wantKind(t, decodeRequest(big, &Team{}), KindTooLarge)
+}
+
+func TestFollowTeamRefIsAbbreviation(t *testing.T) {
+	a := newAPITest(t)
+	mustAddTeams(t, a.store, nbaTeams[0])
+	acc := mustCreateAccount(t, a.store, "alice")
+	token, _ := a.session(t, acc)
+	abbr := nbaTeams[0].Abbr
+
+	rec := a.do(t, "POST", "/v1/me/follows", token, map[string]any{"kind": "team", "ref": strings.ToLower(abbr)})
+	wantStatus(t, rec, http.StatusCreated)
+	follows, err := a.store.GetFollows(context.Background(), acc.Id, FollowTeam, followList.All())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := followRefs(follows); !reflect.DeepEqual(got, []string{"team:" + abbr + "@1"}) {
+		t.Errorf("follows = %v, want %s", got, abbr)
+	}
+
+	rec = a.do(t, "POST", "/v1/me/follows", token, map[string]any{"kind": "team", "ref": "not-a-team"})
+	wantStatus(t, rec, http.StatusUnprocessableEntity)
+	var problem ApiError
+	decodeBody(t, rec, &problem)
+	if len(problem.Errors) != 1 || problem.Errors[0].Field != "ref" {
+		t.Errorf("errors = %+v, want one for ref", problem.Errors)
+	}
+	// Player refs are ids and are not upper cased.
+	wantStatus(t, a.do(t, "POST", "/v1/me/follows", token, map[string]any{"kind": "player", "ref": "abc"}), http.StatusCreated)
+
+	wantStatus(t, a.do(t, "DELETE", "/v1/me/follows/team/"+strings.ToLower(abbr), token, nil), http.StatusOK)
+}
+
+func TestUnlockRequestIsValidated(t *testing.T) {
+	a := newAPITest(t)
+	admin := &Account{Username: "root", EncryptedPassword: "x", IsAdmin: true}
+	if err := a.store.CreateAccount(context.Background(), admin); err != nil {
+		t.Fatal(err)
+	}
+	token, _ := a.session(t, admin)
+
+	for _, body := range []map[string]any{{}, {"ip": "not-an-ip"}, {"username": "  "}} {
+		wantStatus(t, a.do(t, "POST", "/v1/admin/unlock", token, body), http.StatusUnprocessableEntity)
+	}
+	wantStatus(t, a.do(t, "POST", "/v1/admin/unlock", token, map[string]any{"ip": " 2001:db8::1 "}), http.StatusOK)
+	wantStatus(t, a.do(t, "POST", "/v1/admin/unlock", token, map[string]any{"username": "alice"}), http.StatusOK)
+}
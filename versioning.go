@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// legacyRoutes names the subrouter that serves the unversioned aliases of
+// the current API version.
+const legacyRoutes = "legacy"
+
+// LegacyPolicy is announced on every response from a legacy route.
+type LegacyPolicy struct {
+	Deprecated time.Time
+	Sunset     time.Time
+}
+
+func (c APIConfig) Legacy() (LegacyPolicy, error) {
+	var policy LegacyPolicy
+	var err error
+	if policy.Deprecated, err = parseConfigDate(c.LegacyDeprecated); err != nil {
+		return policy, fmt.Errorf("legacy_deprecated: %w", err)
+	}
+	if policy.Sunset, err = parseConfigDate(c.LegacySunset); err != nil {
+		return policy, fmt.Errorf("legacy_sunset: %w", err)
+	}
+	if !policy.Sunset.IsZero() && policy.Sunset.Before(policy.Deprecated) {
+		return policy, fmt.Errorf("legacy_sunset must not be before legacy_deprecated")
+	}
+	return policy, nil
+}
+
+func parseConfigDate(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.DateOnly, s)
+}
+
+// Deprecated adds the Deprecation (RFC 9745) and Sunset (RFC 8594) headers
+// to legacy responses, with a link to the same path under successor.
+func Deprecated(successor string, policy LegacyPolicy) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if policy.Deprecated.IsZero() {
+				w.Header().Set("Deprecation", "@0")
+			} else {
+				w.Header().Set("Deprecation", fmt.Sprintf("@%d", policy.Deprecated.Unix()))
+			}
+			if !policy.Sunset.IsZero() {
+				w.Header().Set("Sunset", policy.Sunset.UTC().Format(http.TimeFormat))
+			}
+			w.Header().Add("Link", fmt.Sprintf(`<%s%s>; rel="successor-version"`, successor, r.URL.EscapedPath()))
+			next.ServeHTTP(w, r)
+		})
+	}
+}
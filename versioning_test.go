@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// withLegacy rebuilds the router of a so legacy routes announce policy.
+func (a *apiTest) withLegacy(policy LegacyPolicy) *apiTest {
+	a.s.legacy = policy
+	a.handler = a.s.Handler()
+	return a
+}
+
+func TestVersionedAndLegacyRoutes(t *testing.T) {
+	policy := LegacyPolicy{
+		Deprecated: time.Date(2026, 1, 31, 0, 0, 0, 0, time.UTC),
+		Sunset:     time.Date(2027, 4, 30, 0, 0, 0, 0, time.UTC),
+	}
+	a := newAPITest(t).withLegacy(policy)
+	acc := mustCreateAccount(t, a.store, "alice")
+	token, _ := a.session(t, acc)
+
+	versioned := a.do(t, "GET", "/v1/me", token, nil)
+	wantStatus(t, versioned, http.StatusOK)
+	for _, h := range []string{"Deprecation", "Sunset", "Link"} {
+		if v := versioned.Header().Get(h); v != "" {
+			t.Errorf("/v1/me %s = %q, want none", h, v)
+		}
+	}
+
+	legacy := a.do(t, "GET", "/me", token, nil)
+	wantStatus(t, legacy, http.StatusOK)
+	if legacy.Body.String() != versioned.Body.String() {
+		t.Errorf("/me body = %s, want the /v1/me body %s", legacy.Body, versioned.Body)
+	}
+	if got, want := legacy.Header().Get("Deprecation"), "@"+strconv.FormatInt(policy.Deprecated.Unix(), 10); got != want {
+		t.Errorf("Deprecation = %q, want %q", got, want)
+	}
+	if got, want := legacy.Header().Get("Sunset"), "Fri, 30 Apr 2027 00:00:00 GMT"; got != want {
+		t.Errorf("Sunset = %q, want %q", got, want)
+	}
+	if got, want := legacy.Header().Get("Link"), `</v1/me>; rel="successor-version"`; got != want {
+		t.Errorf("Link = %q, want %q", got, want)
+	}
+
+	// Errors from legacy routes are announced too.
+	rec := a.do(t, "GET", "/me", "", nil)
+	wantStatus(t, rec, http.StatusUnauthorized)
+	if rec.Header().Get("Deprecation") == "" || rec.Header().Get("Sunset") == "" {
+		t.Errorf("401 headers = %v, want Deprecation and Sunset", rec.Header())
+	}
+
+	// The successor link sits next to the pagination link.
+	for _, ref := range []string{"1", "2"} {
+		f := &Follow{FollowTarget: FollowTarget{Kind: FollowPlayer, Ref: ref}}
+		if _, err := a.store.Follow(context.Background(), acc.Id, f); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rec = a.do(t, "GET", "/me/follows?limit=1", token, nil)
+	wantStatus(t, rec, http.StatusOK)
+	links := rec.Header().Values("Link")
+	if len(links) != 2 || links[0] != `</v1/me/follows>; rel="successor-version"` || !nextLink.MatchString(links[1]) {
+		t.Errorf("Link = %q, want the successor and the next page", links)
+	}
+
+	// Only the unversioned paths are aliases.
+	wantStatus(t, a.do(t, "GET", "/v1/v1/me", token, nil), http.StatusNotFound)
+}
+
+func TestLegacyWithoutDates(t *testing.T) {
+	a := newAPITest(t)
+	rec := a.do(t, "GET", "/me", "", nil)
+	if got := rec.Header().Get("Deprecation"); got != "@0" {
+		t.Errorf("Deprecation = %q, want @0", got)
+	}
+	if got := rec.Header().Get("Sunset"); got != "" {
+		t.Errorf("Sunset = %q, want none", got)
+	}
+}
+
+func TestLegacyConfig(t *testing.T) {
+	policy, err := APIConfig{LegacyDeprecated: "2026-01-31", LegacySunset: "2027-04-30"}.Legacy()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !policy.Sunset.Equal(time.Date(2027, 4, 30, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("sunset = %s", policy.Sunset)
+	}
+	for _, c := range []APIConfig{
+		{LegacySunset: "30/04/2027"},
+		{LegacyDeprecated: "2027-05-01", LegacySunset: "2027-04-30"},
+	} {
+		if _, err := c.Legacy(); err == nil {
+			t.Errorf("%+v was accepted", c)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+)
+
+// Workers runs background jobs beside the HTTP server. Jobs run until
+// their context is cancelled, which happens once the server has drained.
+type Workers struct {
+	mu      sync.Mutex
+	jobs    map[string]func(context.Context) error
+	running map[string]bool
+	cancel  context.CancelFunc
+	wg      sync.WaitGroup
+}
+
+func NewWorkers() *Workers {
+	return &Workers{jobs: map[string]func(context.Context) error{}, running: map[string]bool{}}
+}
+
+// Add registers a job to be started by Start.
+func (w *Workers) Add(name string, run func(context.Context) error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.jobs[name] = run
+}
+
+// Start runs every job in its own goroutine. A job that returns early is
+// logged and reported as not running.
+func (w *Workers) Start(ctx context.Context) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	ctx, w.cancel = context.WithCancel(ctx)
+	for name, run := range w.jobs {
+		w.running[name] = true
+		w.wg.Add(1)
+		go func(name string, run func(context.Context) error) {
+			defer w.wg.Done()
+			err := run(ctx)
+			w.mu.Lock()
+			w.running[name] = false
+			w.mu.Unlock()
+			if err != nil && ctx.Err() == nil {
+				slog.Error("worker stopped", "worker", name, "err", err)
+			}
+		}(name, run)
+	}
+}
+
+// Stop cancels every job and waits for them to return.
+func (w *Workers) Stop() {
+	w.mu.Lock()
+	cancel := w.cancel
+	w.mu.Unlock()
+	if cancel != nil {
+		cancel()
+	}
+	w.wg.Wait()
+}
+
+// Running reports whether each registered job is still running.
+func (w *Workers) Running() map[string]bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	running := make(map[string]bool, len(w.jobs))
+	for name := range w.jobs {
+		running[name] = w.running[name]
+	}
+	return running
+}